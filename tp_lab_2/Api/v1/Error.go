@@ -2,8 +2,10 @@ package log_v1
 
 import (
 	"fmt"
+	"time"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
@@ -34,3 +36,77 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+// ErrDiskFull is returned for appends rejected while the log is in
+// read-only mode because its disk is running out of space.
+type ErrDiskFull struct {
+	Dir  string
+	Free uint64
+}
+
+func (e ErrDiskFull) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.ResourceExhausted,
+		fmt.Sprintf("log is read-only: %d bytes free in %s", e.Free, e.Dir),
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: "The log is out of disk space and only serves reads until space is freed",
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrDiskFull) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrSchemaViolation is returned for records rejected because they do not
+// carry a valid schema ID or their value does not match the schema.
+type ErrSchemaViolation struct {
+	SchemaID uint64
+	Reason   string
+}
+
+func (e ErrSchemaViolation) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.InvalidArgument,
+		fmt.Sprintf("schema violation (schema %d): %s", e.SchemaID, e.Reason),
+	)
+	d := &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       "record.value",
+			Description: e.Reason,
+		}},
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrSchemaViolation) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrClockSkew is returned for records carrying a hybrid logical clock
+// timestamp too far ahead of the server's clock.
+type ErrClockSkew struct {
+	WallTime int64
+	Skew     time.Duration
+}
+
+func (e ErrClockSkew) GRPCStatus() *status.Status {
+	return status.New(
+		codes.InvalidArgument,
+		fmt.Sprintf("record timestamp %d is %s ahead of the server clock", e.WallTime, e.Skew),
+	)
+}
+
+func (e ErrClockSkew) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
@@ -14,9 +14,10 @@ var (
 )
 
 type index struct {
-	file *os.File
-	mmap gommap.MMap
-	size uint64
+	file     *os.File
+	mmap     gommap.MMap
+	size     uint64
+	readOnly bool
 }
 
 func newIndex(f *os.File, c Config) (*index, error) {
@@ -43,7 +44,40 @@ func newIndex(f *os.File, c Config) (*index, error) {
 	return idx, nil
 }
 
+// openIndex maps an existing index read-only, without growing the file.
+// storeSize is the size of the segment's store.
+func openIndex(f *os.File, storeSize uint64) (*index, error) {
+	idx := &index{
+		file:     f,
+		readOnly: true,
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return idx, nil
+	}
+	if idx.mmap, err = gommap.Map(
+		idx.file.Fd(),
+		gommap.PROT_READ,
+		gommap.MAP_SHARED,
+	); err != nil {
+		return nil, err
+	}
+	idx.size = uint64(len(indexEntries(idx.mmap, storeSize))) * entWidth
+	return idx, nil
+}
+
 func (i *index) Close() error {
+	if i.readOnly {
+		if i.mmap != nil {
+			if err := i.mmap.UnsafeUnmap(); err != nil {
+				return err
+			}
+		}
+		return i.file.Close()
+	}
 	if err := i.mmap.Sync(gommap.MS_ASYNC); err != nil {
 		return err
 	}
@@ -60,6 +94,17 @@ func (i *index) Close() error {
 
 }
 
+// Sync commits the index's entries to stable storage.
+func (i *index) Sync() error {
+	if i.readOnly {
+		return nil
+	}
+	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
+		return err
+	}
+	return i.file.Sync()
+}
+
 func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 	if i.size == 0 {
 		return 0, 0, io.EOF
@@ -73,12 +118,18 @@ func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 	if i.size < pos+entWidth {
 		return 0, 0, io.EOF
 	}
-	out = enc.Uint32(i.mmap[pos : pos+offWidth])
-	pos = enc.Uint64(i.mmap[pos+offWidth : pos+entWidth])
+	out, pos = readEntry(i.mmap, pos)
 	return out, pos, nil
 }
+
+// readEntry decodes the index entry stored at pos in b.
+func readEntry(b []byte, pos uint64) (off uint32, storePos uint64) {
+	off = enc.Uint32(b[pos : pos+offWidth])
+	storePos = enc.Uint64(b[pos+offWidth : pos+entWidth])
+	return off, storePos
+}
 func (i *index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.mmap)) < i.size+entWidth {
+	if i.readOnly || uint64(len(i.mmap)) < i.size+entWidth {
 		return io.EOF
 	}
 
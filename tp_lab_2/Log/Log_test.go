@@ -3,7 +3,9 @@ package log
 import (
 	"io"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	api "example.com/tpmod/Api/v1"
 	"github.com/stretchr/testify/require"
@@ -106,8 +108,10 @@ func testReader(t *testing.T, log *Log) {
 	b, err := io.ReadAll(reader)
 	require.NoError(t, err)
 
+	p, _, err := decodeRecord(currentFormat, b[lenWidth:], off)
+	require.NoError(t, err)
 	read := &api.Record{}
-	err = proto.Unmarshal(b[lenWidth:], read)
+	err = proto.Unmarshal(p, read)
 	require.NoError(t, err)
 	require.Equal(t, append.Value, read.Value)
 }
@@ -130,3 +134,149 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+// END: truncate
+
+// START: roll
+func TestRoll(t *testing.T) {
+	dir, err := os.MkdirTemp("", "roll-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxSegmentAge = 50 * time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// rolling an empty segment is a no-op
+	require.NoError(t, log.Roll())
+	require.Len(t, log.snapshot(), 1)
+
+	append := &api.Record{Value: []byte("hello world")}
+	_, err = log.Append(append)
+	require.NoError(t, err)
+	require.NoError(t, log.Roll())
+	segments := log.snapshot()
+	require.Len(t, segments, 2)
+	require.True(t, segments[0].Sealed())
+	require.Equal(t, uint64(1), segments[1].baseOffset)
+
+	// an idle segment is sealed once it gets older than MaxSegmentAge
+	_, err = log.Append(append)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(log.snapshot()) == 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(2), log.snapshot()[2].baseOffset)
+
+	read, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+}
+
+func TestRollAfterReopen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "roll-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxSegmentAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	append := &api.Record{Value: []byte("hello world")}
+	_, err = log.Append(append)
+	require.NoError(t, err)
+	created := log.activeSegment.createdAt
+	time.Sleep(10 * time.Millisecond)
+	_, err = log.Append(append)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	// the age counts from the first record, not the last write
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.True(t, created.Equal(log.activeSegment.createdAt))
+}
+
+// END: roll
+
+// START: bench
+func benchmarkLog(b *testing.B, records int) *Log {
+	b.Helper()
+	dir, err := os.MkdirTemp("", "log-bench")
+	require.NoError(b, err)
+	b.Cleanup(func() { os.RemoveAll(dir) })
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	l, err := NewLog(dir, c)
+	require.NoError(b, err)
+	b.Cleanup(func() { l.Close() })
+
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < records; i++ {
+		_, err := l.Append(record)
+		require.NoError(b, err)
+	}
+	return l
+}
+
+func BenchmarkRead(b *testing.B) {
+	const records = 10000
+	l := benchmarkLog(b, records)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var off uint64
+		for pb.Next() {
+			if _, err := l.Read(off % records); err != nil {
+				// Fatal may only be called from the benchmark goroutine
+				b.Error(err)
+				return
+			}
+			off += 7919
+		}
+	})
+}
+
+func BenchmarkReadDuringAppend(b *testing.B) {
+	const records = 10000
+	l := benchmarkLog(b, records)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	// registered after benchmarkLog's, so it runs before the log is closed
+	b.Cleanup(func() {
+		close(done)
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		record := &api.Record{Value: []byte("hello world")}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if _, err := l.Append(record); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var off uint64
+		for pb.Next() {
+			if _, err := l.Read(off % records); err != nil {
+				// Fatal may only be called from the benchmark goroutine
+				b.Error(err)
+				return
+			}
+			off += 7919
+		}
+	})
+}
+
+// END: bench
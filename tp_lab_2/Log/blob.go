@@ -0,0 +1,89 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Values larger than Config.Segment.MaxRecordBytes are kept out of the
+// store in a side-car blob file named after the record's offset. The
+// record is stored without its value and its batch carries attrBlob.
+const (
+	blobExt = ".blob"
+
+	attrBlob uint16 = 1 << 0
+)
+
+func blobPath(dir string, off uint64) string {
+	return segmentPath(dir, off, blobExt)
+}
+
+// writeBlob writes value to the blob file of off, replacing it atomically.
+func writeBlob(dir string, off uint64, value []byte) error {
+	name := blobPath(dir, off)
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(value); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func readBlob(dir string, off uint64) ([]byte, error) {
+	return os.ReadFile(blobPath(dir, off))
+}
+
+// blobOffsets lists dir once for the offsets in [from, to) with a blob
+// file.
+func blobOffsets(dir string, from, to uint64) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var offs []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), blobExt)
+		if !ok {
+			continue
+		}
+		off, err := strconv.ParseUint(name, 10, 64)
+		if err == nil && off >= from && off < to {
+			offs = append(offs, off)
+		}
+	}
+	return offs, nil
+}
+
+// removeBlobs removes the blob files of offsets in [from, to) from dir.
+func removeBlobs(dir string, from, to uint64) error {
+	offs, err := blobOffsets(dir, from, to)
+	if err != nil {
+		return err
+	}
+	for _, off := range offs {
+		if err := os.Remove(blobPath(dir, off)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasBlobs reports whether any offset in [from, to) has a blob file in dir.
+func hasBlobs(dir string, from, to uint64) bool {
+	offs, err := blobOffsets(dir, from, to)
+	return err == nil && len(offs) > 0
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobs(t *testing.T) {
+	dir, err := os.MkdirTemp("", "blob-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 128
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	large := &api.Record{Value: bytes.Repeat([]byte("x"), 4096)}
+	small := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		off, err := log.Append(large)
+		require.NoError(t, err)
+		require.Len(t, large.Value, 4096)
+		_, err = os.Stat(blobPath(dir, off))
+		require.NoError(t, err)
+		_, err = log.Append(small)
+		require.NoError(t, err)
+	}
+	for off := uint64(0); off < 6; off += 2 {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, large.Value, read.Value)
+		read, err = log.Read(off + 1)
+		require.NoError(t, err)
+		require.Equal(t, small.Value, read.Value)
+	}
+	// the large values don't count against the segment size
+	require.Len(t, log.snapshot(), 2)
+
+	// the raw stores don't hold the large values
+	_, err = io.ReadAll(log.Reader())
+	require.ErrorIs(t, err, ErrBlobRecords)
+	require.NoError(t, log.Close())
+	reopened, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer reopened.Close()
+	_, err = io.ReadAll(reopened.Reader())
+	require.ErrorIs(t, err, ErrBlobRecords)
+
+	require.NoError(t, reopened.Roll())
+	require.NoError(t, reopened.Truncate(5))
+	for off := uint64(0); off < 6; off += 2 {
+		_, err = os.Stat(blobPath(dir, off))
+		require.True(t, os.IsNotExist(err))
+	}
+	_, err = io.ReadAll(reopened.Reader())
+	require.NoError(t, err)
+}
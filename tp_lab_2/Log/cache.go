@@ -0,0 +1,56 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+)
+
+// segmentCache keeps at most max sealed segments open, closing the least
+// recently read ones. A max of zero keeps every segment open.
+type segmentCache struct {
+	mu    sync.Mutex
+	max   int
+	lru   *list.List
+	elems map[*segment]*list.Element
+}
+
+func newSegmentCache(max int) *segmentCache {
+	return &segmentCache{
+		max:   max,
+		lru:   list.New(),
+		elems: make(map[*segment]*list.Element),
+	}
+}
+
+// touch marks s as the most recently used segment and returns the
+// segments that should be closed to stay within the limit.
+func (c *segmentCache) touch(s *segment) []*segment {
+	if c.max <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.elems[s]; ok {
+		c.lru.MoveToFront(e)
+	} else {
+		c.elems[s] = c.lru.PushFront(s)
+	}
+	var evicted []*segment
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		victim := e.Value.(*segment)
+		delete(c.elems, victim)
+		evicted = append(evicted, victim)
+	}
+	return evicted
+}
+
+func (c *segmentCache) remove(s *segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.elems[s]; ok {
+		c.lru.Remove(e)
+		delete(c.elems, s)
+	}
+}
@@ -0,0 +1,65 @@
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLazySegments(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	append := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 10; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	segments := log.snapshot()
+	require.Len(t, segments, 6)
+	// only the active segment is opened on startup
+	for _, s := range segments[:len(segments)-1] {
+		require.False(t, s.IsOpen())
+	}
+	require.True(t, segments[len(segments)-1].IsOpen())
+
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	off, err = log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), off)
+
+	for off := uint64(0); off < 10; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	// the least recently read segments were closed again
+	var open int
+	for _, s := range segments[:len(segments)-1] {
+		if s.IsOpen() {
+			open++
+		}
+	}
+	require.Equal(t, 2, open)
+	require.False(t, segments[0].IsOpen())
+
+	b, err := io.ReadAll(log.Reader())
+	require.NoError(t, err)
+	require.NotEmpty(t, b)
+}
@@ -0,0 +1,50 @@
+package log
+
+import (
+	"errors"
+
+	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/hlc"
+)
+
+// stamp sets the hybrid logical clock timestamp of record. Stamping under
+// mu keeps timestamps increasing with offsets.
+func (l *Log) stamp(record *api.Record) error {
+	var ts hlc.Timestamp
+	if record.Hlc == nil {
+		ts = l.Config.Clock.Now()
+	} else {
+		var err error
+		ts, err = l.Config.Clock.Update(hlc.Timestamp{
+			WallTime: record.Hlc.WallTime,
+			Logical:  record.Hlc.Logical,
+		})
+		var skew *hlc.SkewError
+		if errors.As(err, &skew) {
+			return api.ErrClockSkew{WallTime: skew.Remote.WallTime, Skew: skew.Skew}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	record.Hlc = &api.HLC{WallTime: ts.WallTime, Logical: ts.Logical}
+	return nil
+}
+
+// restoreClock moves the clock past the timestamp of the last record, in
+// case the wall clock went back while the log was closed.
+func (l *Log) restoreClock() error {
+	segments := l.snapshot()
+	next := segments[len(segments)-1].NextOffset()
+	if next == segments[0].baseOffset {
+		return nil
+	}
+	record, err := l.read(next - 1)
+	if err != nil {
+		return err
+	}
+	if ts := record.Hlc; ts != nil {
+		l.Config.Clock.Restore(hlc.Timestamp{WallTime: ts.WallTime, Logical: ts.Logical})
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/hlc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	dir, err := os.MkdirTemp("", "clock-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var wall int64 = 1000
+	c := Config{}
+	c.Clock = hlc.NewClock(func() int64 { return wall }, time.Duration(100))
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	off, err := l.Append(&api.Record{Value: []byte("local")})
+	require.NoError(t, err)
+	read, err := l.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, &api.HLC{WallTime: 1000}, read.Hlc)
+
+	// a producer's timestamp ahead of ours is kept causally before
+	off, err = l.Append(&api.Record{
+		Value: []byte("caused"),
+		Hlc:   &api.HLC{WallTime: 1050, Logical: 3},
+	})
+	require.NoError(t, err)
+	read, err = l.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, &api.HLC{WallTime: 1050, Logical: 4}, read.Hlc)
+
+	_, err = l.Append(&api.Record{
+		Value: []byte("skewed"),
+		Hlc:   &api.HLC{WallTime: 2000},
+	})
+	require.ErrorAs(t, err, &api.ErrClockSkew{})
+	require.NoError(t, l.Close())
+
+	// a restarted log with its wall clock behind keeps counting up
+	wall = 500
+	c.Clock = hlc.NewClock(func() int64 { return wall }, 0)
+	l, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	off, err = l.Append(&api.Record{Value: []byte("after restart")})
+	require.NoError(t, err)
+	read, err = l.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, &api.HLC{WallTime: 1050, Logical: 5}, read.Hlc)
+}
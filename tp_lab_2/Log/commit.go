@@ -0,0 +1,142 @@
+package log
+
+import (
+	"sync"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+)
+
+// appendRequest is an append waiting in the commit queue.
+type appendRequest struct {
+	record *api.Record
+	queued time.Time
+	off    uint64
+	err    error
+	// done is closed once the record is written, lead once the caller
+	// has to write the next batch itself.
+	done chan struct{}
+	lead chan struct{}
+}
+
+// commitQueue coalesces concurrent appends. The first caller to find the
+// queue idle becomes the leader: it writes every queued request, each in
+// a frame of its own, syncing the segment once for all of them, and then
+// hands the lead to the first request that queued up while it was writing. Requests are written in
+// queue order, so offsets follow arrival order.
+type commitQueue struct {
+	mu      sync.Mutex
+	pending []*appendRequest
+	leading bool
+	// full wakes a leader waiting for its batch to fill up.
+	full chan struct{}
+}
+
+// join queues req and reports whether its caller leads the next batch.
+func (q *commitQueue) join(req *appendRequest, max int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.full == nil {
+		q.full = make(chan struct{}, 1)
+	}
+	q.pending = append(q.pending, req)
+	if q.leading {
+		if max > 0 && len(q.pending) >= max {
+			select {
+			case q.full <- struct{}{}:
+			default:
+			}
+		}
+		return false
+	}
+	q.leading = true
+	return true
+}
+
+// wait gives other appends up to d to join the batch.
+func (q *commitQueue) wait(d time.Duration, max int) {
+	q.mu.Lock()
+	n := len(q.pending)
+	q.mu.Unlock()
+	if max > 0 && n >= max {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-q.full:
+	}
+}
+
+// take removes up to max requests from the head of the queue.
+func (q *commitQueue) take(max int) []*appendRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.pending)
+	if max > 0 && n > max {
+		n = max
+	}
+	batch := q.pending[:n:n]
+	q.pending = q.pending[n:]
+	return batch
+}
+
+// handOff passes the lead to the next queued request, if any.
+func (q *commitQueue) handOff() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		q.leading = false
+		return
+	}
+	close(q.pending[0].lead)
+}
+
+// lead writes the next batch of queued appends, which includes the
+// leader's own request, and passes the lead on.
+func (l *Log) lead() {
+	c := l.Config.Commit
+	if c.FlushInterval > 0 {
+		l.commits.wait(c.FlushInterval, c.MaxBatch)
+	}
+	l.commit(l.commits.take(c.MaxBatch))
+	l.commits.handOff()
+}
+
+func (l *Log) commit(batch []*appendRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkDisk(); err != nil {
+		for _, req := range batch {
+			req.err = err
+		}
+		l.metrics.committed(batch)
+		for _, req := range batch {
+			close(req.done)
+		}
+		return
+	}
+	for _, req := range batch {
+		req.off, req.err = l.append(req.record)
+	}
+	if l.Config.Commit.Sync {
+		// segments rolled mid-batch were synced when sealed
+		err := l.activeSegment.Sync()
+		if err == nil && l.tree != nil {
+			err = l.tree.sync()
+		}
+		if err != nil {
+			for _, req := range batch {
+				if req.err == nil {
+					req.err = err
+				}
+			}
+		}
+	}
+	l.notifyAppended()
+	l.metrics.committed(batch)
+	for _, req := range batch {
+		close(req.done)
+	}
+}
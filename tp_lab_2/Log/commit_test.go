@@ -0,0 +1,151 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCommit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "commit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 256
+	c.Commit.FlushInterval = time.Millisecond
+	c.Commit.MaxBatch = 8
+	c.Commit.Sync = true
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	const writers, appends = 16, 20
+	offsets := make([][]uint64, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < appends; i++ {
+				off, err := log.Append(&api.Record{
+					Value: []byte(fmt.Sprintf("%d-%d", w, i)),
+				})
+				require.NoError(t, err)
+				offsets[w] = append(offsets[w], off)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	for w, offs := range offsets {
+		for i, off := range offs {
+			// each caller gets its own offset, in the order it appended
+			require.False(t, seen[off])
+			seen[off] = true
+			if i > 0 {
+				require.Greater(t, off, offs[i-1])
+			}
+			read, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("%d-%d", w, i), string(read.Value))
+		}
+	}
+	require.Len(t, seen, writers*appends)
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(writers*appends-1), off)
+}
+
+func TestAppendAsync(t *testing.T) {
+	dir, err := os.MkdirTemp("", "commit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Commit.FlushInterval = time.Millisecond
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	errOdd := errors.New("odd value")
+	log.Use(func(ctx context.Context, record *api.Record, next AppendFunc) (uint64, error) {
+		if record.Value[0]%2 == 1 {
+			return 0, errOdd
+		}
+		return next(ctx, record)
+	})
+
+	// appends queued from one goroutine get offsets in call order
+	var waits []func() (uint64, error)
+	for i := 0; i < 40; i++ {
+		waits = append(waits, log.AppendAsync(context.Background(), &api.Record{
+			Value: []byte{byte(i)},
+		}))
+	}
+	want := uint64(0)
+	for i, wait := range waits {
+		off, err := wait()
+		if i%2 == 1 {
+			require.ErrorIs(t, err, errOdd)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, want, off)
+		want++
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(i)}, read.Value)
+	}
+}
+
+func TestSyncedAppendsSurviveCrash(t *testing.T) {
+	dir, err := os.MkdirTemp("", "commit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Commit.Sync = true
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// copy the files as a crash would leave them, with the index still
+	// at its full size
+	crashed, err := os.MkdirTemp("", "commit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(crashed)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(crashed, e.Name()), b, 0644))
+	}
+	fi, err := os.Stat(segmentPath(crashed, 0, indexExt))
+	require.NoError(t, err)
+	require.Equal(t, int64(log.Config.Segment.MaxIndexBytes), fi.Size())
+
+	reopened, err := NewLog(crashed, c)
+	require.NoError(t, err)
+	defer reopened.Close()
+	off, err := reopened.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	off, err = reopened.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
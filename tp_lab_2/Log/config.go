@@ -1,9 +1,79 @@
 package log
 
+import (
+	"crypto/ed25519"
+	"time"
+
+	"example.com/tpmod/hlc"
+	"example.com/tpmod/metrics"
+)
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		// MaxRecordBytes is the largest value stored inline; bigger ones
+		// go to a blob file next to the segment. It defaults to
+		// MaxStoreBytes.
+		MaxRecordBytes uint64
+		// MaxSegmentAge seals the active segment once its first record
+		// is older than this. Zero disables rolling by age.
+		MaxSegmentAge time.Duration
+		// MaxOpenSegments bounds how many sealed segments keep their
+		// files open; the least recently read are closed first. Zero
+		// keeps them all open.
+		MaxOpenSegments int
+	}
+	Commit struct {
+		// FlushInterval is how long the caller writing a batch of
+		// concurrent appends waits for more to join it. Zero writes
+		// right away, batching whatever queued up meanwhile.
+		FlushInterval time.Duration
+		// MaxBatch caps how many appends are written together. Zero
+		// means no limit.
+		MaxBatch int
+		// Sync fsyncs the store and the index once per batch before
+		// acknowledging its appends.
+		Sync bool
+	}
+	Disk struct {
+		// LowWatermark is the free space, in bytes, under which the log
+		// turns read-only and rejects appends. Zero disables the check.
+		LowWatermark uint64
+		// HighWatermark is the free space appends resume at once the
+		// log turned read-only. Between the two watermarks the log keeps
+		// its state: a writable log goes on appending down to
+		// LowWatermark and a read-only one goes on rejecting appends up
+		// to HighWatermark, so that it doesn't flap around a single
+		// threshold. It defaults to LowWatermark.
+		HighWatermark uint64
+		// CheckInterval is how often free space is measured. Zero
+		// measures it before every batch of appends.
+		CheckInterval time.Duration
+	}
+	Merkle struct {
+		// Enabled covers every record with a leaf of a Merkle tree, so
+		// clients can check the log was not rewritten.
+		Enabled bool
+		// SigningKey signs the tree heads handed out by TreeHead.
+		SigningKey ed25519.PrivateKey
+		// TreeHeadInterval is how often a new tree head is signed. Zero
+		// signs one on every call to TreeHead.
+		TreeHeadInterval time.Duration
 	}
+	// Clock, when set, stamps every appended record with a hybrid
+	// logical clock timestamp, merging the one the producer set if any.
+	// Without it records keep no timestamp, since none would be
+	// validated. Processes hosting several logs should share one clock.
+	Clock *hlc.Clock
+	// Metrics, when set, gets the log's metrics: appends, reads and
+	// their latencies, commit batch sizes, segment rolls and counts.
+	// Register one log per registry.
+	Metrics *metrics.Registry
+	// Dirs lists data directories new segments may be placed in besides
+	// the log's own directory.
+	Dirs []string
+	// Placement picks the directory of each new segment.
+	Placement Placement
 }
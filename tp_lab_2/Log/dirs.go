@@ -0,0 +1,222 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placement picks the data directory a new segment is created in.
+type Placement int
+
+const (
+	// PlaceRoundRobin cycles through the data directories.
+	PlaceRoundRobin Placement = iota
+	// PlaceMostFreeSpace picks the directory with the most free space.
+	PlaceMostFreeSpace
+)
+
+// ErrDirOffline reports a data directory taken out of use.
+type ErrDirOffline struct {
+	Dir string
+	Err error
+}
+
+func (e ErrDirOffline) Error() string {
+	return fmt.Sprintf("data directory %s offline: %v", e.Dir, e.Err)
+}
+
+func (e ErrDirOffline) Unwrap() error {
+	return e.Err
+}
+
+// dirSet tracks the data directories of a log and which of them are
+// still usable.
+type dirSet struct {
+	mu        sync.Mutex
+	all       []string
+	offline   map[string]error
+	placement Placement
+	next      int
+}
+
+func newDirSet(dir string, c Config) *dirSet {
+	d := &dirSet{
+		offline:   make(map[string]error),
+		placement: c.Placement,
+	}
+	seen := make(map[string]bool)
+	for _, dir := range append([]string{dir}, c.Dirs...) {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		d.all = append(d.all, dir)
+	}
+	return d
+}
+
+// scan creates dir if needed and returns the base offsets of its
+// segments, taking the directory offline when it can't be read.
+func (d *dirSet) scan(dir string) ([]uint64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		d.setOffline(dir, err)
+		return nil, err
+	}
+	baseOffsets, err := segmentBases(dir)
+	if err != nil {
+		d.setOffline(dir, err)
+		return nil, err
+	}
+	return baseOffsets, nil
+}
+
+func (d *dirSet) setOffline(dir string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.offline[dir] = err
+}
+
+func (d *dirSet) offlineDirs() map[string]error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dirs := make(map[string]error, len(d.offline))
+	for dir, err := range d.offline {
+		dirs[dir] = ErrDirOffline{Dir: dir, Err: err}
+	}
+	return dirs
+}
+
+func (d *dirSet) online() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var dirs []string
+	for _, dir := range d.all {
+		if _, ok := d.offline[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// place returns the directory the next segment should be created in.
+func (d *dirSet) place() (string, error) {
+	dirs := d.online()
+	if len(dirs) == 0 {
+		return "", d.errNoneOnline()
+	}
+	if d.placement == PlaceMostFreeSpace {
+		best, bestFree := "", uint64(0)
+		for _, dir := range dirs {
+			free, err := freeSpace(dir)
+			if err != nil {
+				continue
+			}
+			if best == "" || free > bestFree {
+				best, bestFree = dir, free
+			}
+		}
+		if best != "" {
+			return best, nil
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dir := dirs[d.next%len(dirs)]
+	d.next++
+	return dir, nil
+}
+
+// newestFile, kept in every online data directory, holds the base offset
+// of the active segment, the offset its records stay below and when it got
+// its first record, in Unix nanoseconds or 0 while it has none. A log
+// whose active segment is in a directory it can't read starts the next
+// segment at that offset rather than reuse the offsets the segment holds.
+const newestFile = "newest-segment"
+
+// newestMark is the content of newestFile.
+type newestMark struct {
+	base, limit uint64
+	created     time.Time
+}
+
+// mark records m as the newest segment in every online directory, taking
+// offline the ones it can't be written to.
+func (d *dirSet) mark(m newestMark) error {
+	marked := false
+	for _, dir := range d.online() {
+		if err := writeNewest(dir, m); err != nil {
+			d.setOffline(dir, err)
+			continue
+		}
+		marked = true
+	}
+	if !marked {
+		return d.errNoneOnline()
+	}
+	return nil
+}
+
+func writeNewest(dir string, m newestMark) error {
+	tmp := filepath.Join(dir, newestFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var created int64
+	if !m.created.IsZero() {
+		created = m.created.UnixNano()
+	}
+	if _, err = fmt.Fprintf(f, "%d %d %d\n", m.base, m.limit, created); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, newestFile))
+}
+
+// newest returns the newest segment marked in the online directories,
+// with ok false if none was.
+func (d *dirSet) newest() (newest newestMark, ok bool, err error) {
+	for _, dir := range d.online() {
+		name := filepath.Join(dir, newestFile)
+		b, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return newestMark{}, false, err
+		}
+		var m newestMark
+		var created int64
+		// marks written before the creation time was kept end early
+		if n, err := fmt.Sscan(string(b), &m.base, &m.limit, &created); n < 2 {
+			return newestMark{}, false, fmt.Errorf("%s: %w", name, err)
+		}
+		if created != 0 {
+			m.created = time.Unix(0, created)
+		}
+		if !ok || m.base > newest.base {
+			newest, ok = m, true
+		}
+	}
+	return newest, ok, nil
+}
+
+func (d *dirSet) errNoneOnline() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []string
+	for _, dir := range d.all {
+		errs = append(errs, ErrDirOffline{Dir: dir, Err: d.offline[dir]}.Error())
+	}
+	return errors.New("no data directory available: " + strings.Join(errs, "; "))
+}
@@ -0,0 +1,126 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipleDirs(t *testing.T) {
+	root, err := os.MkdirTemp("", "dirs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	dir, other := filepath.Join(root, "a"), filepath.Join(root, "b")
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Dirs = []string{other}
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	append := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 6; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	// segments are placed round robin across the directories
+	segments := log.snapshot()
+	require.Len(t, segments, 4)
+	for i, s := range segments {
+		require.Equal(t, []string{dir, other}[i%2], s.dir)
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Len(t, log.snapshot(), 4)
+	for off := uint64(0); off < 6; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	require.Empty(t, log.OfflineDirs())
+	require.NoError(t, log.Close())
+
+	// an unreadable directory is reported and the log keeps going
+	require.NoError(t, os.RemoveAll(other))
+	require.NoError(t, os.WriteFile(other, nil, 0644))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	offline := log.OfflineDirs()
+	require.Len(t, offline, 1)
+	var dirErr ErrDirOffline
+	require.ErrorAs(t, offline[other], &dirErr)
+	require.Equal(t, other, dirErr.Dir)
+
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	for _, s := range log.snapshot() {
+		require.Equal(t, dir, s.dir)
+	}
+}
+
+func TestOfflineNewestSegment(t *testing.T) {
+	root, err := os.MkdirTemp("", "dirs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	dir, other := filepath.Join(root, "a"), filepath.Join(root, "b")
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Dirs = []string{other}
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	append := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	// the active segment, holding offset 2, is in the other directory
+	segments := log.snapshot()
+	require.Equal(t, other, segments[len(segments)-1].dir)
+	require.NoError(t, log.Close())
+
+	// take it offline and keep appending
+	hidden := filepath.Join(root, "hidden")
+	require.NoError(t, os.Rename(other, hidden))
+	require.NoError(t, os.WriteFile(other, nil, 0644))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Len(t, log.OfflineDirs(), 1)
+	offsets := make(map[uint64]bool)
+	for i := 0; i < 3; i++ {
+		off, err := log.Append(append)
+		require.NoError(t, err)
+		require.Greater(t, off, uint64(2))
+		offsets[off] = true
+	}
+	require.NoError(t, log.Close())
+
+	// once back, both directories' records are there
+	require.NoError(t, os.Remove(other))
+	require.NoError(t, os.Rename(hidden, other))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Empty(t, log.OfflineDirs())
+	for off := uint64(0); off < 3; off++ {
+		offsets[off] = true
+	}
+	for off := range offsets {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	off, err := log.Append(append)
+	require.NoError(t, err)
+	require.False(t, offsets[off])
+}
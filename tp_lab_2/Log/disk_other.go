@@ -0,0 +1,9 @@
+//go:build !(linux || darwin)
+
+package log
+
+import "errors"
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space unavailable on this platform")
+}
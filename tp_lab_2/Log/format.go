@@ -0,0 +1,144 @@
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Each store frame holds a uint64 length followed by a payload whose
+// layout depends on the format version of the segment:
+//
+//	formatV1: the marshaled record.
+//	formatV2: a batch header followed by Count records, each prefixed by
+//	its uvarint length.
+//
+// The batch header is
+//
+//	magic      uint32
+//	version    uint8
+//	attributes uint16
+//	baseOffset uint64
+//	count      uint32
+//	crc        uint32
+//
+// The crc is a Castagnoli checksum of attributes, baseOffset and count,
+// then of the records after the header; magic and version are left out.
+const (
+	formatV1      uint8 = 1
+	formatV2      uint8 = 2
+	currentFormat       = formatV2
+
+	batchMagic uint32 = 0x54504c42 // "TPLB"
+
+	magicWidth       = 4
+	versionWidth     = 1
+	attributesWidth  = 2
+	baseOffsetWidth  = 8
+	countWidth       = 4
+	crcWidth         = 4
+	batchHeaderWidth = magicWidth + versionWidth + attributesWidth +
+		baseOffsetWidth + countWidth + crcWidth
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorruptBatch = errors.New("corrupt batch")
+)
+
+// batch is a group of consecutive records written as one store frame.
+type batch struct {
+	version    uint8
+	attributes uint16
+	baseOffset uint64
+	records    [][]byte
+}
+
+func (b *batch) encode() []byte {
+	size := batchHeaderWidth
+	for _, r := range b.records {
+		size += binary.MaxVarintLen64 + len(r)
+	}
+	p := make([]byte, batchHeaderWidth, size)
+	enc.PutUint32(p[0:], batchMagic)
+	p[magicWidth] = b.version
+	enc.PutUint16(p[magicWidth+versionWidth:], b.attributes)
+	enc.PutUint64(p[magicWidth+versionWidth+attributesWidth:], b.baseOffset)
+	enc.PutUint32(p[batchHeaderWidth-crcWidth-countWidth:], uint32(len(b.records)))
+	for _, r := range b.records {
+		p = binary.AppendUvarint(p, uint64(len(r)))
+		p = append(p, r...)
+	}
+	enc.PutUint32(p[batchHeaderWidth-crcWidth:], batchCRC(p))
+	return p
+}
+
+func batchCRC(p []byte) uint32 {
+	crc := crc32.Checksum(p[magicWidth+versionWidth:batchHeaderWidth-crcWidth], crcTable)
+	return crc32.Update(crc, crcTable, p[batchHeaderWidth:])
+}
+
+func decodeBatch(p []byte) (*batch, error) {
+	if len(p) < batchHeaderWidth || enc.Uint32(p) != batchMagic {
+		return nil, errCorruptBatch
+	}
+	b := &batch{
+		version:    p[magicWidth],
+		attributes: enc.Uint16(p[magicWidth+versionWidth:]),
+		baseOffset: enc.Uint64(p[magicWidth+versionWidth+attributesWidth:]),
+	}
+	if b.version != formatV2 {
+		return nil, fmt.Errorf("unsupported batch version %d", b.version)
+	}
+	if crc := enc.Uint32(p[batchHeaderWidth-crcWidth:]); crc != batchCRC(p) {
+		return nil, fmt.Errorf("%w: crc mismatch", errCorruptBatch)
+	}
+	count := enc.Uint32(p[batchHeaderWidth-crcWidth-countWidth:])
+	rest := p[batchHeaderWidth:]
+	for i := uint32(0); i < count; i++ {
+		n, w := binary.Uvarint(rest)
+		if w <= 0 || uint64(len(rest)-w) < n {
+			return nil, fmt.Errorf("%w: truncated record %d", errCorruptBatch, i)
+		}
+		b.records = append(b.records, rest[w:w+int(n)])
+		rest = rest[w+int(n):]
+	}
+	return b, nil
+}
+
+// decodeRecord returns the marshaled record with offset off from a frame
+// payload written in the given format, along with its batch attributes.
+func decodeRecord(version uint8, p []byte, off uint64) ([]byte, uint16, error) {
+	if version == formatV1 {
+		return p, 0, nil
+	}
+	b, err := decodeBatch(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	if off < b.baseOffset || off-b.baseOffset >= uint64(len(b.records)) {
+		return nil, 0, fmt.Errorf("%w: offset %d not in batch at %d", errCorruptBatch, off, b.baseOffset)
+	}
+	return b.records[off-b.baseOffset], b.attributes, nil
+}
+
+// detectFormat returns the format of the store read by r from its first
+// frame. Empty stores are written in the current format.
+func detectFormat(r io.ReaderAt) (uint8, error) {
+	head := make([]byte, lenWidth+magicWidth)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	switch {
+	case n == 0:
+		return currentFormat, nil
+	case n == len(head) && enc.Uint32(head[lenWidth:]) == batchMagic:
+		return formatV2, nil
+	default:
+		return formatV1, nil
+	}
+}
@@ -0,0 +1,93 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBatch(t *testing.T) {
+	want := &batch{
+		version:    formatV2,
+		baseOffset: 16,
+		records:    [][]byte{[]byte("hello"), []byte("world")},
+	}
+	p := want.encode()
+	got, err := decodeBatch(p)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	r, _, err := decodeRecord(formatV2, p, 17)
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), r)
+	_, _, err = decodeRecord(formatV2, p, 18)
+	require.ErrorIs(t, err, errCorruptBatch)
+
+	p[len(p)-1] ^= 0xff
+	_, err = decodeBatch(p)
+	require.ErrorIs(t, err, errCorruptBatch)
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "migrate-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// write a segment in the legacy format: bare length-prefixed records
+	var store, index []byte
+	for off := uint64(0); off < 3; off++ {
+		p, err := proto.Marshal(&api.Record{
+			Value:  []byte("hello world"),
+			Offset: off,
+		})
+		require.NoError(t, err)
+		entry := make([]byte, entWidth)
+		enc.PutUint32(entry[:offWidth], uint32(off))
+		enc.PutUint64(entry[offWidth:], uint64(len(store)))
+		index = append(index, entry...)
+		store = enc.AppendUint64(store, uint64(len(p)))
+		store = append(store, p...)
+	}
+	require.NoError(t, os.WriteFile(segmentPath(dir, 0, storeExt), store, 0644))
+	require.NoError(t, os.WriteFile(segmentPath(dir, 0, indexExt), index, 0644))
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, formatV1, log.activeSegment.version)
+	for off := uint64(0); off < 3; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+	// the legacy segment keeps its format; the next one uses the new one
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, formatV2, log.activeSegment.version)
+	require.NoError(t, log.Close())
+
+	migrated, err := Migrate(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, migrated)
+	problems, err := Verify(dir)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+	migrated, err = Migrate(dir)
+	require.NoError(t, err)
+	require.Empty(t, migrated)
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for off := uint64(0); off < 5; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+	}
+}
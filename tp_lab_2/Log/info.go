@@ -0,0 +1,85 @@
+package log
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// Info summarizes the state of a log.
+type Info struct {
+	LowestOffset uint64
+	// NextOffset is the offset the next record gets. The log is empty
+	// while it equals LowestOffset.
+	NextOffset uint64
+	Segments   int
+	// Bytes is the size of the files in the log's data directories that
+	// are online.
+	Bytes uint64
+}
+
+// Info returns the offset range of the log, its number of segments and
+// the space it takes on disk.
+func (l *Log) Info() (Info, error) {
+	usage, err := l.DiskUsage()
+	if err != nil {
+		return Info{}, err
+	}
+	segments := l.snapshot()
+	info := Info{
+		LowestOffset: segments[0].baseOffset,
+		NextOffset:   segments[len(segments)-1].NextOffset(),
+		Segments:     len(segments),
+	}
+	for _, u := range usage {
+		info.Bytes += u.Bytes
+	}
+	return info, nil
+}
+
+// DirUsage is the disk usage of a data directory of a log.
+type DirUsage struct {
+	Dir string
+	// Bytes is the size of the files in the directory.
+	Bytes uint64
+	// Free is the free space of its file system, zero when unknown.
+	Free uint64
+	// Offline is the error that took the directory out of use. Offline
+	// directories are not measured.
+	Offline error
+}
+
+// DiskUsage measures the data directories of the log.
+func (l *Log) DiskUsage() ([]DirUsage, error) {
+	l.mu.RLock()
+	dirs := l.dirs
+	l.mu.RUnlock()
+	offline := dirs.offlineDirs()
+	var usage []DirUsage
+	for _, dir := range dirs.all {
+		u := DirUsage{Dir: dir, Offline: offline[dir]}
+		if u.Offline != nil {
+			usage = append(usage, u)
+			continue
+		}
+		err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				var fi fs.FileInfo
+				if fi, err = d.Info(); err == nil {
+					u.Bytes += uint64(fi.Size())
+				}
+			}
+			// segments may be removed while we walk
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		u.Free, _ = diskFree(dir)
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
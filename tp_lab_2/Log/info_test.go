@@ -0,0 +1,37 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo(t *testing.T) {
+	dir, err := os.MkdirTemp("", "info-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+
+	info, err := l.Info()
+	require.NoError(t, err)
+	require.Equal(t, info.LowestOffset, info.NextOffset)
+	require.Equal(t, 1, info.Segments)
+
+	for i := 0; i < 10; i++ {
+		_, err = l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	info, err = l.Info()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), info.LowestOffset)
+	require.Equal(t, uint64(10), info.NextOffset)
+	require.Greater(t, info.Segments, 1)
+	require.GreaterOrEqual(t, info.Bytes, uint64(10*len("hello world")))
+}
@@ -0,0 +1,338 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// SegmentInfo describes a segment as it is stored on disk.
+type SegmentInfo struct {
+	BaseOffset uint64 `json:"base_offset"`
+	NextOffset uint64 `json:"next_offset"`
+	StoreBytes uint64 `json:"store_bytes"`
+	IndexBytes uint64 `json:"index_bytes"`
+	StorePath  string `json:"store_path"`
+	IndexPath  string `json:"index_path"`
+	// LastModified is when a record was last written to the segment.
+	LastModified time.Time `json:"last_modified"`
+	// Sealed is set for the segments of an open log that no longer take
+	// appends.
+	Sealed bool `json:"sealed,omitempty"`
+}
+
+// Records returns the number of records indexed by the segment.
+func (s SegmentInfo) Records() uint64 {
+	return s.NextOffset - s.BaseOffset
+}
+
+// Problem is an inconsistency found while verifying a segment.
+type Problem struct {
+	BaseOffset uint64 `json:"base_offset"`
+	Offset     uint64 `json:"offset"`
+	Reason     string `json:"reason"`
+}
+
+// Gap is a range of offsets [From, To) not covered by any segment.
+type Gap struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+type indexEntry struct {
+	off uint32
+	pos uint64
+}
+
+// The functions below read segment files directly and are meant for
+// offline inspection: the log owning dir must not be open.
+
+// Segments lists the segments found in dir ordered by base offset.
+func Segments(dir string) ([]SegmentInfo, error) {
+	bases, err := segmentBases(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SegmentInfo, 0, len(bases))
+	for _, base := range bases {
+		info, _, err := readSegmentInfo(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Dump calls fn with every record whose offset is in [from, to).
+func Dump(dir string, from, to uint64, fn func(*api.Record) error) error {
+	bases, err := segmentBases(dir)
+	if err != nil {
+		return err
+	}
+	for _, base := range bases {
+		info, entries, err := readSegmentInfo(dir, base)
+		if err != nil {
+			return err
+		}
+		if info.NextOffset <= from || to <= info.BaseOffset {
+			continue
+		}
+		f, err := os.Open(info.StorePath)
+		if err != nil {
+			return err
+		}
+		version, err := detectFormat(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		for i, e := range entries {
+			off := base + uint64(i)
+			if off < from || to <= off {
+				continue
+			}
+			record, err := readRecord(f, dir, version, e.pos, off)
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("offset %d: %w", off, err)
+			}
+			if err = fn(record); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks that every index entry in dir points at a readable store
+// frame holding the record with the matching offset.
+func Verify(dir string) ([]Problem, error) {
+	bases, err := segmentBases(dir)
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	for _, base := range bases {
+		p, err := verifySegment(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p...)
+	}
+	return problems, nil
+}
+
+func verifySegment(dir string, base uint64) ([]Problem, error) {
+	info, entries, err := readSegmentInfo(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	problem := func(off uint64, format string, args ...interface{}) Problem {
+		return Problem{
+			BaseOffset: base,
+			Offset:     off,
+			Reason:     fmt.Sprintf(format, args...),
+		}
+	}
+	f, err := os.Open(info.StorePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Problem{problem(base, "missing store file")}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	version, err := detectFormat(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	var prev, next uint64
+	for i, e := range entries {
+		off := base + uint64(i)
+		if e.off != uint32(i) {
+			problems = append(problems, problem(off,
+				"index entry %d has relative offset %d", i, e.off))
+		}
+		// records of a batch share the position of its frame
+		if e.pos != next && (version == formatV1 || i == 0 || e.pos != prev) {
+			problems = append(problems, problem(off,
+				"index position %d, want %d", e.pos, next))
+		}
+		p, err := readFrame(f, e.pos)
+		if err != nil {
+			problems = append(problems, problem(off,
+				"reading frame at %d: %v", e.pos, err))
+			break
+		}
+		prev, next = e.pos, e.pos+lenWidth+uint64(len(p))
+		p, attrs, err := decodeRecord(version, p, off)
+		if err != nil {
+			problems = append(problems, problem(off,
+				"decoding batch: %v", err))
+			continue
+		}
+		if attrs&attrBlob != 0 {
+			if _, err := os.Stat(blobPath(dir, off)); err != nil {
+				problems = append(problems, problem(off,
+					"blob file: %v", err))
+			}
+		}
+		record := &api.Record{}
+		if err = proto.Unmarshal(p, record); err != nil {
+			problems = append(problems, problem(off,
+				"decoding record: %v", err))
+			continue
+		}
+		if record.Offset != off {
+			problems = append(problems, problem(off,
+				"record holds offset %d", record.Offset))
+		}
+	}
+	if next < info.StoreBytes {
+		problems = append(problems, problem(info.NextOffset,
+			"%d store bytes not covered by the index", info.StoreBytes-next))
+	}
+	return problems, nil
+}
+
+// RebuildIndex rewrites the index of the segment with the given base
+// offset from the frames in its store and returns the number of entries.
+// A partially written frame at the end of the store is left unindexed.
+func RebuildIndex(dir string, base uint64) (uint64, error) {
+	f, err := os.Open(segmentPath(dir, base, storeExt))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	version, err := detectFormat(f)
+	if err != nil {
+		return 0, err
+	}
+
+	var b []byte
+	var pos uint64
+	var n uint32
+	for {
+		p, err := readFrame(f, pos)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		records := 1
+		if version != formatV1 {
+			batch, err := decodeBatch(p)
+			if err != nil {
+				return 0, fmt.Errorf("frame at %d: %w", pos, err)
+			}
+			records = len(batch.records)
+		}
+		for i := 0; i < records; i++ {
+			entry := make([]byte, entWidth)
+			enc.PutUint32(entry[:offWidth], n)
+			enc.PutUint64(entry[offWidth:], pos)
+			b = append(b, entry...)
+			n++
+		}
+		pos += lenWidth + uint64(len(p))
+	}
+
+	name := segmentPath(dir, base, indexExt)
+	tmp := name + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(tmp, name); err != nil {
+		return 0, err
+	}
+	return uint64(len(b)) / entWidth, nil
+}
+
+// Gaps returns the offset ranges missing between consecutive segments.
+func Gaps(dir string) ([]Gap, error) {
+	infos, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	var gaps []Gap
+	for i := 1; i < len(infos); i++ {
+		prev, cur := infos[i-1], infos[i]
+		if prev.NextOffset < cur.BaseOffset {
+			gaps = append(gaps, Gap{From: prev.NextOffset, To: cur.BaseOffset})
+		}
+	}
+	return gaps, nil
+}
+
+func readSegmentInfo(dir string, base uint64) (SegmentInfo, []indexEntry, error) {
+	info := SegmentInfo{
+		BaseOffset: base,
+		NextOffset: base,
+		StorePath:  segmentPath(dir, base, storeExt),
+		IndexPath:  segmentPath(dir, base, indexExt),
+	}
+	if fi, err := os.Stat(info.StorePath); err == nil {
+		info.StoreBytes = uint64(fi.Size())
+		info.LastModified = fi.ModTime()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return info, nil, err
+	}
+	b, err := os.ReadFile(info.IndexPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return info, nil, err
+	}
+	entries := indexEntries(b, info.StoreBytes)
+	info.IndexBytes = uint64(len(entries)) * entWidth
+	info.NextOffset = base + uint64(len(entries))
+	return info, entries, nil
+}
+
+// indexEntries decodes the entries of an index file, stopping at the zero
+// padding left behind when the index was not closed cleanly. The first
+// record's entry is zero too, so a zero first entry only counts when the
+// store, of storeSize bytes, holds a record.
+func indexEntries(b []byte, storeSize uint64) []indexEntry {
+	var entries []indexEntry
+	for pos := uint64(0); pos+entWidth <= uint64(len(b)); pos += entWidth {
+		off, storePos := readEntry(b, pos)
+		if off == 0 && storePos == 0 && (len(entries) > 0 || storeSize == 0) {
+			break
+		}
+		entries = append(entries, indexEntry{off: off, pos: storePos})
+	}
+	return entries
+}
+
+func readRecord(r io.ReaderAt, dir string, version uint8, pos, off uint64) (*api.Record, error) {
+	p, err := readFrame(r, pos)
+	if err != nil {
+		return nil, err
+	}
+	p, attrs, err := decodeRecord(version, p, off)
+	if err != nil {
+		return nil, err
+	}
+	record := &api.Record{}
+	if err = proto.Unmarshal(p, record); err != nil {
+		return nil, err
+	}
+	if attrs&attrBlob != 0 {
+		if record.Value, err = readBlob(dir, off); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
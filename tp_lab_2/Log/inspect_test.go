@@ -0,0 +1,89 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	dir, err := os.MkdirTemp("", "inspect-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, err := l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	segments, err := Segments(dir)
+	require.NoError(t, err)
+	require.Len(t, segments, 4)
+	require.Equal(t, uint64(0), segments[0].BaseOffset)
+	require.Equal(t, uint64(2), segments[0].NextOffset)
+	require.Equal(t, uint64(2), segments[0].Records())
+	require.Equal(t, uint64(2*entWidth), segments[0].IndexBytes)
+
+	var offsets []uint64
+	err = Dump(dir, 1, 4, func(record *api.Record) error {
+		offsets = append(offsets, record.Offset)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3}, offsets)
+
+	problems, err := Verify(dir)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// a lost index can be rebuilt from its store
+	require.NoError(t, os.Remove(segments[1].IndexPath))
+	problems, err = Verify(dir)
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	n, err := RebuildIndex(dir, segments[1].BaseOffset)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), n)
+	problems, err = Verify(dir)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	gaps, err := Gaps(dir)
+	require.NoError(t, err)
+	require.Empty(t, gaps)
+	require.NoError(t, os.Remove(segments[1].StorePath))
+	require.NoError(t, os.Remove(segments[1].IndexPath))
+	gaps, err = Gaps(dir)
+	require.NoError(t, err)
+	require.Equal(t, []Gap{{From: 2, To: 4}}, gaps)
+}
+
+func TestInspectPaddedEmptyIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "inspect-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a crash before the first append leaves an empty store and an index
+	// of nothing but padding
+	require.NoError(t, os.WriteFile(segmentPath(dir, 16, storeExt), nil, 0644))
+	require.NoError(t, os.WriteFile(segmentPath(dir, 16, indexExt), make([]byte, 4*entWidth), 0644))
+
+	segments, err := Segments(dir)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	require.Equal(t, uint64(0), segments[0].Records())
+	problems, err := Verify(dir)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	s, err := loadSegment(dir, 16, Config{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(16), s.NextOffset())
+}
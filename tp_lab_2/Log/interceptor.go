@@ -0,0 +1,116 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/tracing"
+)
+
+// AppendFunc appends a record to the log and returns its offset.
+type AppendFunc func(ctx context.Context, record *api.Record) (uint64, error)
+
+// AppendInterceptor runs before a record is appended. It may modify the
+// record, reject it by returning an error without calling next, or call
+// next to hand the record to the rest of the chain.
+type AppendInterceptor func(ctx context.Context, record *api.Record, next AppendFunc) (uint64, error)
+
+// Use registers interceptors to run before every append, in the order
+// given and after the ones registered earlier.
+func (l *Log) Use(interceptors ...AppendInterceptor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.interceptors.Load()
+	var chain []AppendInterceptor
+	if old != nil {
+		chain = append(chain, *old...)
+	}
+	chain = append(chain, interceptors...)
+	l.interceptors.Store(&chain)
+}
+
+// ReadInterceptor runs on every record returned by Read and may rewrite
+// it, for instance to decode a value an AppendInterceptor encoded.
+type ReadInterceptor func(record *api.Record) error
+
+// UseRead registers interceptors to run, in the order given, on every
+// record returned by Read.
+func (l *Log) UseRead(interceptors ...ReadInterceptor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	old := l.readInterceptors.Load()
+	var chain []ReadInterceptor
+	if old != nil {
+		chain = append(chain, *old...)
+	}
+	chain = append(chain, interceptors...)
+	l.readInterceptors.Store(&chain)
+}
+
+// AppendContext runs record through the interceptors and appends it.
+// Frontends call it with their request's context so interceptors can see
+// who produced the record. When ctx holds a span, the append is traced in
+// a child of it the interceptors see in their context.
+func (l *Log) AppendContext(ctx context.Context, record *api.Record) (off uint64, err error) {
+	ctx, span := tracing.Start(ctx, "log.Append")
+	defer func() {
+		span.SetAttribute("offset", strconv.FormatUint(off, 10))
+		span.End(err)
+	}()
+	chain := l.interceptors.Load()
+	if chain == nil {
+		return l.commitAppend(record)
+	}
+	return chainInterceptors(*chain, func(_ context.Context, record *api.Record) (uint64, error) {
+		return l.commitAppend(record)
+	})(ctx, record)
+}
+
+// AppendAsync runs record through the interceptors and queues it behind
+// the appends queued before it, without waiting for it to be written.
+// The returned function waits and returns its offset. Callers appending
+// from one goroutine get offsets in call order while the records are
+// written in as few batches as possible. Interceptors see 0 as the offset
+// returned by the end of the chain.
+//
+// Its span, when ctx holds one, ends once the returned function returns.
+func (l *Log) AppendAsync(ctx context.Context, record *api.Record) func() (uint64, error) {
+	ctx, span := tracing.Start(ctx, "log.Append")
+	var req *appendRequest
+	enqueue := func(_ context.Context, record *api.Record) (uint64, error) {
+		req = l.enqueue(record)
+		return 0, nil
+	}
+	var err error
+	if chain := l.interceptors.Load(); chain == nil {
+		_, err = enqueue(ctx, record)
+	} else {
+		_, err = chainInterceptors(*chain, enqueue)(ctx, record)
+	}
+	if err == nil && req == nil {
+		err = errors.New("append interceptor dropped the record")
+	}
+	if err != nil {
+		span.End(err)
+		return func() (uint64, error) { return 0, err }
+	}
+	return func() (uint64, error) {
+		<-req.done
+		span.SetAttribute("offset", strconv.FormatUint(req.off, 10))
+		span.End(req.err)
+		return req.off, req.err
+	}
+}
+
+func chainInterceptors(chain []AppendInterceptor, final AppendFunc) AppendFunc {
+	next := final
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, rest := chain[i], next
+		next = func(ctx context.Context, record *api.Record) (uint64, error) {
+			return interceptor(ctx, record, rest)
+		}
+	}
+	return next
+}
@@ -0,0 +1,56 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendInterceptors(t *testing.T) {
+	dir, err := os.MkdirTemp("", "interceptor-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer l.Close()
+
+	type ctxKey struct{}
+	var order []string
+	errEmpty := errors.New("empty value")
+	l.Use(
+		func(ctx context.Context, record *api.Record, next AppendFunc) (uint64, error) {
+			order = append(order, "validate")
+			if len(record.Value) == 0 {
+				return 0, errEmpty
+			}
+			return next(ctx, record)
+		},
+		func(ctx context.Context, record *api.Record, next AppendFunc) (uint64, error) {
+			order = append(order, "stamp")
+			record.Headers = map[string]string{"who": ctx.Value(ctxKey{}).(string)}
+			return next(ctx, record)
+		},
+	)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "alice")
+	off, err := l.AppendContext(ctx, &api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"validate", "stamp"}, order)
+
+	read, err := l.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"who": "alice"}, read.Headers)
+
+	// a rejected record is never appended
+	order = nil
+	_, err = l.AppendContext(ctx, &api.Record{})
+	require.ErrorIs(t, err, errEmpty)
+	require.Equal(t, []string{"validate"}, order)
+	_, err = l.Read(off + 1)
+	require.Error(t, err)
+}
@@ -0,0 +1,324 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"os"
+	"sync"
+)
+
+// Each segment keeps a key index in a side file: a header holding how
+// many of the segment's records the file covers, then an entry for each
+// of those records with a key, holding its offset relative to the segment
+// and the hash of its key. Entries are buffered and written, along with
+// the header, when the segment is synced, sealed or closed. The index is
+// loaded into memory on first use and rebuilt from the store for the
+// records the header doesn't cover.
+const (
+	keysExt         = ".keys"
+	keysHeaderWidth = 8
+	keyEntryWidth   = 4 + 8
+	// keysBufferBytes is how many bytes of entries are buffered before
+	// they are written even though the segment wasn't synced.
+	keysBufferBytes = 4096
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+
+type keyIndex struct {
+	mu sync.RWMutex
+	// file is open for writes while the segment is active, size being
+	// its length and pending the entries not written to it yet.
+	file    *os.File
+	size    int64
+	pending []byte
+	offsets map[uint64][]uint32
+	// count is how many of the segment's records are indexed, written
+	// counting the ones in the file.
+	count, written uint64
+}
+
+func keyHash(key []byte) uint64 {
+	if len(key) == 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write(key)
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// loadKeyIndex reads the key index of s, indexing the records its file
+// doesn't cover, and keeps the file open for writes when writable is set.
+func loadKeyIndex(s *segment, writable bool) (*keyIndex, error) {
+	name := segmentPath(s.dir, s.baseOffset, keysExt)
+	b, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	k := &keyIndex{offsets: make(map[uint64][]uint32)}
+	records := s.NextOffset() - s.baseOffset
+	var entries []byte
+	if len(b) >= keysHeaderWidth {
+		k.count, entries = enc.Uint64(b), b[keysHeaderWidth:]
+	}
+	if k.count > records {
+		// entries for records that never made it to the store
+		k.count = records
+	}
+	valid := 0
+	for ; valid+keyEntryWidth <= len(entries); valid += keyEntryWidth {
+		rel := enc.Uint32(entries[valid:])
+		if uint64(rel) >= k.count {
+			break
+		}
+		k.add(rel, enc.Uint64(entries[valid+4:]))
+	}
+	rewrite := len(b) < keysHeaderWidth || valid != len(entries) || k.count < records
+	b = append(make([]byte, keysHeaderWidth), entries[:valid]...)
+	for off := s.baseOffset + k.count; off < s.NextOffset(); off++ {
+		record, err := s.Read(off)
+		if err != nil {
+			return nil, err
+		}
+		rel := uint32(off - s.baseOffset)
+		if h := keyHash(record.Key); h != 0 {
+			k.add(rel, h)
+			b = appendKeyEntry(b, rel, h)
+		}
+	}
+	k.count = records
+	k.written = records
+	enc.PutUint64(b, records)
+	if rewrite {
+		if err = os.WriteFile(name, b, 0644); err != nil {
+			return nil, err
+		}
+	}
+	if writable {
+		if k.file, err = os.OpenFile(name, os.O_WRONLY, 0644); err != nil {
+			return nil, err
+		}
+		k.size = int64(len(b))
+	}
+	return k, nil
+}
+
+func appendKeyEntry(b []byte, rel uint32, h uint64) []byte {
+	return enc.AppendUint64(enc.AppendUint32(b, rel), h)
+}
+
+func (k *keyIndex) add(rel uint32, h uint64) {
+	k.offsets[h] = append(k.offsets[h], rel)
+}
+
+// append indexes the key of the record at relative offset rel. Records
+// without a key are only counted.
+func (k *keyIndex) append(rel uint64, key []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.file == nil || k.count != rel {
+		return errors.New("key index out of sync")
+	}
+	if h := keyHash(key); h != 0 {
+		k.add(uint32(rel), h)
+		k.pending = appendKeyEntry(k.pending, uint32(rel), h)
+	}
+	k.count++
+	if len(k.pending) >= keysBufferBytes {
+		return k.flushLocked()
+	}
+	return nil
+}
+
+// flush writes the buffered entries and the header.
+func (k *keyIndex) flush() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.flushLocked()
+}
+
+func (k *keyIndex) flushLocked() error {
+	if k.file == nil || k.written == k.count {
+		return nil
+	}
+	// entries go first so the header never covers missing ones
+	if _, err := k.file.WriteAt(k.pending, k.size); err != nil {
+		return err
+	}
+	k.size += int64(len(k.pending))
+	k.pending = k.pending[:0]
+	if _, err := k.file.WriteAt(enc.AppendUint64(nil, k.count), 0); err != nil {
+		return err
+	}
+	k.written = k.count
+	return nil
+}
+
+// lookup returns the relative offsets of the records whose key hashes
+// like key, oldest first.
+func (k *keyIndex) lookup(key []byte) []uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	offs := k.offsets[keyHash(key)]
+	return offs[:len(offs):len(offs)]
+}
+
+// close writes the buffered entries and closes the file, keeping the
+// index.
+func (k *keyIndex) close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.file == nil {
+		return nil
+	}
+	err := k.flushLocked()
+	if cerr := k.file.Close(); err == nil {
+		err = cerr
+	}
+	k.file = nil
+	return err
+}
+
+// keyIndex returns the segment's key index, loading it on first use.
+func (s *segment) keyIndex() (*keyIndex, error) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if s.keys == nil {
+		k, err := loadKeyIndex(s, !s.Sealed())
+		if err != nil {
+			return nil, err
+		}
+		s.keys = k
+	}
+	return s.keys, nil
+}
+
+// appendKey indexes the key of the record being appended at off. A key
+// index that fails to update is dropped and rebuilt on next use.
+func (s *segment) appendKey(off uint64, key []byte) {
+	var k *keyIndex
+	var err error
+	if len(key) == 0 {
+		// a record without a key isn't worth loading the index for; it
+		// is counted if the index is loaded already
+		s.keysMu.Lock()
+		k = s.keys
+		s.keysMu.Unlock()
+		if k == nil {
+			return
+		}
+	} else if k, err = s.keyIndex(); err != nil {
+		s.dropKeys()
+		return
+	}
+	if err = k.append(off-s.baseOffset, key); err != nil {
+		s.dropKeys()
+	}
+}
+
+// flushKeys writes the buffered entries of the key index.
+func (s *segment) flushKeys() error {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if s.keys == nil {
+		return nil
+	}
+	return s.keys.flush()
+}
+
+// closeKeys stops writes to the key index file, keeping the index.
+func (s *segment) closeKeys() error {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if s.keys == nil {
+		return nil
+	}
+	return s.keys.close()
+}
+
+// dropKeys forgets the key index, leaving its file as it is.
+func (s *segment) dropKeys() {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if s.keys != nil {
+		s.keys.mu.Lock()
+		if s.keys.file != nil {
+			s.keys.file.Close()
+			s.keys.file = nil
+		}
+		s.keys.mu.Unlock()
+		s.keys = nil
+	}
+}
+
+// LatestOffset returns the offset of the newest record with key.
+func (l *Log) LatestOffset(key []byte) (uint64, error) {
+	segments := l.snapshot()
+	for i := len(segments) - 1; i >= 0; i-- {
+		offs, err := l.keyOffsets(segments[i], key, 0, segments[i].NextOffset())
+		if err != nil {
+			return 0, err
+		}
+		if len(offs) > 0 {
+			return offs[len(offs)-1], nil
+		}
+	}
+	return 0, ErrKeyNotFound
+}
+
+// OffsetsForKey returns the offsets in [from, to) of the records with
+// key, oldest first.
+func (l *Log) OffsetsForKey(key []byte, from, to uint64) ([]uint64, error) {
+	var offsets []uint64
+	for _, s := range l.snapshot() {
+		if s.NextOffset() <= from || to <= s.baseOffset {
+			continue
+		}
+		offs, err := l.keyOffsets(s, key, from, to)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, offs...)
+	}
+	return offsets, nil
+}
+
+// keyOffsets returns the offsets in [from, to) of the records in s with
+// key, reading each candidate to rule out hash collisions.
+func (l *Log) keyOffsets(s *segment, key []byte, from, to uint64) ([]uint64, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if !s.Sealed() {
+		// a missing index of the active segment is rebuilt from the
+		// store, which mustn't change meanwhile
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+	}
+	k, err := s.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+	var offsets []uint64
+	for _, rel := range k.lookup(key) {
+		off := s.baseOffset + uint64(rel)
+		if off < from || to <= off {
+			continue
+		}
+		record, err := s.Read(off)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(record.Key, key) {
+			offsets = append(offsets, off)
+		}
+	}
+	if s.Sealed() {
+		l.touch(s)
+	}
+	return offsets, nil
+}
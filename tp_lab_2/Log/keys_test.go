@@ -0,0 +1,154 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "keys-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for _, key := range []string{"a", "b", "a", "", "c", "a"} {
+		_, err := log.Append(&api.Record{
+			Key:   []byte(key),
+			Value: []byte("hello world"),
+		})
+		require.NoError(t, err)
+	}
+	testKeyLookups := func() {
+		t.Helper()
+		off, err := log.LatestOffset([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), off)
+		off, err = log.LatestOffset([]byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), off)
+		_, err = log.LatestOffset([]byte("d"))
+		require.Equal(t, ErrKeyNotFound, err)
+
+		offs, err := log.OffsetsForKey([]byte("a"), 0, 6)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{0, 2, 5}, offs)
+		offs, err = log.OffsetsForKey([]byte("a"), 1, 5)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{2}, offs)
+	}
+	testKeyLookups()
+	require.NoError(t, log.Close())
+
+	// lost key indexes are rebuilt from the stores
+	require.NoError(t, os.Remove(segmentPath(dir, 0, keysExt)))
+	require.NoError(t, os.Remove(segmentPath(dir, 4, keysExt)))
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	testKeyLookups()
+	_, err = os.Stat(segmentPath(dir, 0, keysExt))
+	require.NoError(t, err)
+
+	_, err = log.Append(&api.Record{Key: []byte("b"), Value: []byte("hello world")})
+	require.NoError(t, err)
+	off, err := log.LatestOffset([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+
+	require.NoError(t, log.Truncate(1))
+	offs, err := log.OffsetsForKey([]byte("a"), 0, 7)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2, 5}, offs)
+	_, err = os.Stat(segmentPath(dir, 0, keysExt))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestKeyIndexFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "keys-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	name := segmentPath(dir, 0, keysExt)
+	size := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(name)
+		require.NoError(t, err)
+		return fi.Size()
+	}
+
+	for _, key := range []string{"", "", ""} {
+		_, err := log.Append(&api.Record{Key: []byte(key), Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	// records without keys don't touch the index
+	_, err = os.Stat(name)
+	require.True(t, os.IsNotExist(err))
+
+	for _, key := range []string{"a", "", "b"} {
+		_, err := log.Append(&api.Record{Key: []byte(key), Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	// entries are buffered until the segment is synced, and only the
+	// records with keys get one
+	require.Equal(t, int64(keysHeaderWidth), size())
+	require.NoError(t, log.activeSegment.Sync())
+	require.Equal(t, int64(keysHeaderWidth+2*keyEntryWidth), size())
+	require.NoError(t, log.Close())
+
+	// a header left behind its entries is caught up from the store
+	b, err := os.ReadFile(name)
+	require.NoError(t, err)
+	enc.PutUint64(b, 4)
+	require.NoError(t, os.WriteFile(name, b, 0644))
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+	off, err := log.LatestOffset([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+	offs, err := log.OffsetsForKey([]byte("a"), 0, 6)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, offs)
+}
+
+func TestKeyIndexEvicted(t *testing.T) {
+	dir, err := os.MkdirTemp("", "keys-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Segment.MaxOpenSegments = 1
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for _, key := range []string{"a", "b", "a", "b", "a", "b"} {
+		_, err := log.Append(&api.Record{Key: []byte(key), Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	segments := log.snapshot()
+	offs, err := log.OffsetsForKey([]byte("a"), 0, 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, offs)
+	require.NotNil(t, segments[0].keys)
+
+	// closing the idle segment releases its key index too
+	offs, err = log.OffsetsForKey([]byte("b"), 2, 4)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, offs)
+	require.Nil(t, segments[0].keys)
+
+	offs, err = log.OffsetsForKey([]byte("a"), 0, 6)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 2, 4}, offs)
+}
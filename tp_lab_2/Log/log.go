@@ -1,6 +1,9 @@
 package log
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path"
@@ -8,8 +11,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/merkle"
+	"example.com/tpmod/tracing"
 )
 
 type Log struct {
@@ -19,7 +26,33 @@ type Log struct {
 	Config Config
 
 	activeSegment *segment
-	segments      []*segment
+	// segments holds an immutable snapshot of the segment list ordered by
+	// base offset. Writers replace it while holding mu so readers can load
+	// it without locking.
+	segments atomic.Pointer[[]*segment]
+
+	// stopRoller stops the goroutine rolling segments by age.
+	stopRoller func()
+	// stopSigner stops the goroutine signing tree heads.
+	stopSigner func()
+
+	dirs    *dirSet
+	cache   *segmentCache
+	commits commitQueue
+	disk    diskGuard
+
+	// interceptors and readInterceptors hold immutable snapshots of the
+	// registered interceptors, replaced by Use and UseRead.
+	interceptors     atomic.Pointer[[]AppendInterceptor]
+	readInterceptors atomic.Pointer[[]ReadInterceptor]
+
+	tree     *recordTree
+	treeHead atomic.Pointer[merkle.TreeHead]
+
+	// appended is closed and replaced after every batch of appends.
+	appended atomic.Pointer[chan struct{}]
+
+	metrics *logMetrics
 }
 
 // END: begin
@@ -32,11 +65,16 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = 1024
 	}
+	if c.Segment.MaxRecordBytes == 0 {
+		c.Segment.MaxRecordBytes = c.Segment.MaxStoreBytes
+	}
 	l := &Log{
 		Dir:    dir,
 		Config: c,
 	}
-
+	if c.Metrics != nil {
+		l.metrics = newLogMetrics(l, c.Metrics)
+	}
 	return l, l.setup()
 }
 
@@ -44,49 +82,217 @@ func NewLog(dir string, c Config) (*Log, error) {
 
 // START: setup
 func (l *Log) setup() error {
-	files, err := os.ReadDir(l.Dir)
+	l.notifyAppended()
+	l.dirs = newDirSet(l.Dir, l.Config)
+	l.cache = newSegmentCache(l.Config.Segment.MaxOpenSegments)
+	type found struct {
+		dir  string
+		base uint64
+	}
+	var segments []found
+	seen := make(map[uint64]string)
+	for _, dir := range l.dirs.all {
+		baseOffsets, err := l.dirs.scan(dir)
+		if err != nil {
+			// the directory was reported offline, keep going with the rest
+			continue
+		}
+		for _, off := range baseOffsets {
+			if other, ok := seen[off]; ok {
+				return fmt.Errorf("segment %d found in both %s and %s", off, other, dir)
+			}
+			seen[off] = dir
+			segments = append(segments, found{dir: dir, base: off})
+		}
+	}
+	if len(l.dirs.online()) == 0 {
+		return l.dirs.errNoneOnline()
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].base < segments[j].base
+	})
+	newest, marked, err := l.dirs.newest()
 	if err != nil {
 		return err
 	}
-	var baseOffsets []uint64
-	for _, file := range files {
-		offStr := strings.TrimSuffix(
-			file.Name(),
-			path.Ext(file.Name()),
-		)
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
+	active := len(segments) - 1
+	if marked && (active < 0 || newest.base > segments[active].base) {
+		// the active segment is in a directory that can't be read, so
+		// all of these are sealed and the next one starts past its
+		// offsets
+		active = -1
 	}
-	sort.Slice(baseOffsets, func(i, j int) bool {
-		return baseOffsets[i] < baseOffsets[j]
-	})
-	for i := 0; i < len(baseOffsets); i++ {
-		if err = l.newSegment(baseOffsets[i]); err != nil {
+	for i, f := range segments {
+		// only the active segment is opened up front, the sealed ones
+		// are opened when first read
+		load := loadSegment
+		if i == active {
+			load = newSegment
+		}
+		s, err := load(f.dir, f.base, l.Config)
+		if err != nil {
+			return err
+		}
+		if i == active && marked && newest.base == s.baseOffset && !newest.created.IsZero() {
+			s.createdAt = newest.created
+		}
+		if err = l.addSegment(s); err != nil {
 			return err
 		}
-		// baseOffset contains dup for index and store so we skip
-		// the dup
-		i++
 	}
-	if l.segments == nil {
-		if err = l.newSegment(l.Config.Segment.InitialOffset); err != nil {
+	switch {
+	case active >= 0:
+		if err := l.markNewest(l.activeSegment); err != nil {
+			return err
+		}
+	case marked:
+		if err := l.newSegment(newest.limit); err != nil {
 			return err
 		}
+	default:
+		if err := l.newSegment(l.Config.Segment.InitialOffset); err != nil {
+			return err
+		}
+	}
+	if l.Config.Clock != nil {
+		if err := l.restoreClock(); err != nil {
+			return err
+		}
+	}
+	if l.Config.Merkle.Enabled {
+		if err := l.openTree(); err != nil {
+			return err
+		}
+		if l.Config.Merkle.SigningKey != nil {
+			if err := l.signTreeHead(); err != nil {
+				return err
+			}
+			if interval := l.Config.Merkle.TreeHeadInterval; interval > 0 {
+				l.startSigner(interval)
+			}
+		}
+	}
+	if age := l.Config.Segment.MaxSegmentAge; age > 0 {
+		l.startRoller(age)
 	}
 	return nil
 }
 
+// segmentBases returns the sorted base offsets of the segments in dir.
+// Each segment has a store and an index file, so duplicates are skipped.
+func segmentBases(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint64]bool)
+	var baseOffsets []uint64
+	for _, file := range files {
+		ext := path.Ext(file.Name())
+		if ext != storeExt && ext != indexExt {
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ext), 10, 0)
+		if err != nil || seen[off] {
+			continue
+		}
+		seen[off] = true
+		baseOffsets = append(baseOffsets, off)
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool {
+		return baseOffsets[i] < baseOffsets[j]
+	})
+	return baseOffsets, nil
+}
+
 // END: setup
 
 // START: append
+// Append writes record to the log and returns its offset. It runs the
+// interceptors registered with Use under a background context; frontends
+// serving a request should call AppendContext instead.
 func (l *Log) Append(record *api.Record) (uint64, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.AppendContext(context.Background(), record)
+}
+
+// commitAppend queues record for the next commit. Concurrent appends are
+// written and synced together in batches; see commitQueue.
+func (l *Log) commitAppend(record *api.Record) (uint64, error) {
+	req := &appendRequest{
+		record: record,
+		queued: time.Now(),
+		done:   make(chan struct{}),
+		lead:   make(chan struct{}),
+	}
+	if !l.commits.join(req, l.Config.Commit.MaxBatch) {
+		select {
+		case <-req.done:
+			return req.off, req.err
+		case <-req.lead:
+		}
+	}
+	l.lead()
+	return req.off, req.err
+}
+
+// enqueue queues record for the next commit and returns at once, leading
+// or waiting for the lead in the background.
+func (l *Log) enqueue(record *api.Record) *appendRequest {
+	req := &appendRequest{
+		record: record,
+		queued: time.Now(),
+		done:   make(chan struct{}),
+		lead:   make(chan struct{}),
+	}
+	lead := l.commits.join(req, l.Config.Commit.MaxBatch)
+	go func() {
+		if !lead {
+			select {
+			case <-req.done:
+				return
+			case <-req.lead:
+			}
+		}
+		l.lead()
+	}()
+	return req
+}
+
+// append writes a single record; callers must hold mu.
+func (l *Log) append(record *api.Record) (uint64, error) {
+	if l.activeSegment.IsExpired(time.Now()) {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+	}
+	if l.tree != nil && l.tree.err != nil {
+		return 0, l.tree.err
+	}
+	if l.Config.Clock != nil {
+		if err := l.stamp(record); err != nil {
+			return 0, err
+		}
+	} else {
+		// a producer's timestamp is only kept once the clock checked it
+		record.Hlc = nil
+	}
 	off, err := l.activeSegment.Append(record)
 	if err != nil {
 		return 0, err
 	}
+	if off == l.activeSegment.baseOffset {
+		// keep when the segment got its first record for MaxSegmentAge
+		// to count from after a restart. The record is written either
+		// way; without the mark the age counts from the last write.
+		_ = l.markNewest(l.activeSegment)
+	}
+	if l.tree != nil {
+		// the record is in the log even if its leaf isn't; the appends
+		// after it fail until reopening the log adds the leaf
+		_ = l.tree.append(record)
+	}
 	if l.activeSegment.IsMaxed() {
+		l.metrics.rolled()
 		err = l.newSegment(off + 1)
 	}
 	return off, err
@@ -95,48 +301,215 @@ func (l *Log) Append(record *api.Record) (uint64, error) {
 // END: append
 
 // START: read
+// Read returns the record at off after running it through the read
+// interceptors registered with UseRead.
 func (l *Log) Read(off uint64) (*api.Record, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-	var s *segment
-	for _, segment := range l.segments {
-		if segment.baseOffset <= off && off < segment.nextOffset {
-			s = segment
-			break
+	start := time.Now()
+	record, err := l.read(off)
+	l.metrics.read(start, err)
+	if err != nil {
+		return nil, err
+	}
+	if chain := l.readInterceptors.Load(); chain != nil {
+		for _, interceptor := range *chain {
+			if err = interceptor(record); err != nil {
+				return nil, err
+			}
 		}
 	}
+	return record, nil
+}
+
+// ReadContext reads like Read, in a child of the span in ctx if any.
+func (l *Log) ReadContext(ctx context.Context, off uint64) (*api.Record, error) {
+	_, span := tracing.Start(ctx, "log.Read")
+	span.SetAttribute("offset", strconv.FormatUint(off, 10))
+	record, err := l.Read(off)
+	span.End(err)
+	return record, err
+}
+
+// read returns the record at off as it was appended.
+func (l *Log) read(off uint64) (*api.Record, error) {
+	segments := l.snapshot()
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].baseOffset > off
+	}) - 1
 	// START: before
-	if s == nil || s.nextOffset <= off {
+	if i < 0 || segments[i].NextOffset() <= off {
 		return nil, api.ErrOffsetOutOfRange{Offset: off}
 	}
 	// END: before
+	s := segments[i]
+	if s.Sealed() {
+		record, err := s.Read(off)
+		l.touch(s)
+		return record, err
+	}
+	// the active segment's index and store change under appends
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 	return s.Read(off)
 }
 
 // END: read
 
+// START: roll
+// Roll seals the active segment and starts a new one. It does nothing
+// when the active segment is empty.
+func (l *Log) Roll() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.roll()
+}
+
+func (l *Log) roll() error {
+	next := l.activeSegment.NextOffset()
+	if next == l.activeSegment.baseOffset {
+		return nil
+	}
+	l.metrics.rolled()
+	return l.newSegment(next)
+}
+
+// startRoller seals the active segment once it gets older than age, so
+// segments of idle logs roll without waiting for the next append.
+func (l *Log) startRoller(age time.Duration) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		interval := age / 4
+		if interval <= 0 {
+			interval = age
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				l.mu.Lock()
+				if l.activeSegment.IsExpired(now) {
+					_ = l.roll()
+				}
+				l.mu.Unlock()
+			}
+		}
+	}()
+	l.stopRoller = func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// END: roll
+
 // START: newsegment
 func (l *Log) newSegment(off uint64) error {
-	s, err := newSegment(l.Dir, off, l.Config)
-	if err != nil {
-		return err
+	for {
+		dir, err := l.dirs.place()
+		if err != nil {
+			return err
+		}
+		s, err := newSegment(dir, off, l.Config)
+		if err != nil {
+			// fail over to the remaining directories
+			l.dirs.setOffline(dir, err)
+			continue
+		}
+		if err = l.markNewest(s); err != nil {
+			s.Close()
+			return err
+		}
+		return l.addSegment(s)
+	}
+}
+
+// markNewest records s as the newest segment in every data directory. Its
+// index bounds the records it can take.
+func (l *Log) markNewest(s *segment) error {
+	return l.dirs.mark(newestMark{
+		base:    s.baseOffset,
+		limit:   s.baseOffset + l.Config.Segment.MaxIndexBytes/entWidth,
+		created: s.createdAt,
+	})
+}
+
+// addSegment seals the active segment and makes s the active one.
+func (l *Log) addSegment(s *segment) error {
+	if prev := l.activeSegment; prev != nil && !prev.Sealed() {
+		if l.Config.Commit.Sync {
+			if err := prev.Sync(); err != nil {
+				return err
+			}
+		}
+		if l.tree != nil {
+			// truncation removes the segments lost leaves are rebuilt from
+			if err := l.tree.sync(); err != nil {
+				return err
+			}
+		}
+		if err := prev.Seal(); err != nil {
+			return err
+		}
+		l.touch(prev)
 	}
-	l.segments = append(l.segments, s)
+	old := l.snapshot()
+	segments := make([]*segment, len(old), len(old)+1)
+	copy(segments, old)
+	l.setSegments(append(segments, s))
 	l.activeSegment = s
 	return nil
 }
 
+// touch records a read of the sealed segment s and closes the segments
+// pushed out of the open segment cache.
+func (l *Log) touch(s *segment) {
+	for _, idle := range l.cache.touch(s) {
+		// a failed close leaves the segment open; it is retried the
+		// next time the segment is evicted
+		_ = idle.Close()
+	}
+}
+
+// snapshot returns the current segment list, which must not be modified.
+func (l *Log) snapshot() []*segment {
+	if segments := l.segments.Load(); segments != nil {
+		return *segments
+	}
+	return nil
+}
+
+// setSegments publishes a new segment list; callers must hold mu.
+func (l *Log) setSegments(segments []*segment) {
+	l.segments.Store(&segments)
+}
+
 // END: newsegment
 
 // START: close
 func (l *Log) Close() error {
+	if l.stopRoller != nil {
+		l.stopRoller()
+		l.stopRoller = nil
+	}
+	if l.stopSigner != nil {
+		l.stopSigner()
+		l.stopSigner = nil
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	for _, segment := range l.segments {
+	for _, segment := range l.snapshot() {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
+	if l.tree != nil {
+		return l.tree.file.Close()
+	}
 	return nil
 }
 
@@ -144,13 +517,21 @@ func (l *Log) Remove() error {
 	if err := l.Close(); err != nil {
 		return err
 	}
-	return os.RemoveAll(l.Dir)
+	for _, dir := range l.dirs.all {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
+	l.setSegments(nil)
+	l.activeSegment = nil
+	l.tree = nil
 	return l.setup()
 }
 
@@ -158,21 +539,25 @@ func (l *Log) Reset() error {
 
 // START: offsets
 func (l *Log) LowestOffset() (uint64, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-	return l.segments[0].baseOffset, nil
+	segments := l.snapshot()
+	return segments[0].baseOffset, nil
 }
 
 func (l *Log) HighestOffset() (uint64, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-	off := l.segments[len(l.segments)-1].nextOffset
+	segments := l.snapshot()
+	off := segments[len(segments)-1].NextOffset()
 	if off == 0 {
 		return 0, nil
 	}
 	return off - 1, nil
 }
 
+// OfflineDirs returns the data directories taken out of use and the error
+// that made each of them fail.
+func (l *Log) OfflineDirs() map[string]error {
+	return l.dirs.offlineDirs()
+}
+
 // END: offsets
 
 // START: truncate
@@ -180,8 +565,11 @@ func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	var segments []*segment
-	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
+	for _, s := range l.snapshot() {
+		// the active segment stays, even when it holds no record past
+		// lowest
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
+			l.cache.remove(s)
 			if err := s.Remove(); err != nil {
 				return err
 			}
@@ -189,29 +577,38 @@ func (l *Log) Truncate(lowest uint64) error {
 		}
 		segments = append(segments, s)
 	}
-	l.segments = segments
+	l.setSegments(segments)
 	return nil
 }
 
 // END: truncate
 
 // START: reader
+// ErrBlobRecords is returned by the reader of Log.Reader on reaching a
+// segment with records whose values are in blob files, which the raw
+// store doesn't hold. Read such records with Read instead.
+var ErrBlobRecords = errors.New("segment has records whose values are in blob files")
+
+// Reader returns a reader over the raw stores of the segments. It fails
+// with ErrBlobRecords rather than leave out the values of blob records.
 func (l *Log) Reader() io.Reader {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-	readers := make([]io.Reader, len(l.segments))
-	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+	segments := l.snapshot()
+	readers := make([]io.Reader, len(segments))
+	for i, segment := range segments {
+		readers[i] = &originReader{segment, 0}
 	}
 	return io.MultiReader(readers...)
 }
 
 type originReader struct {
-	*store
+	*segment
 	off int64
 }
 
 func (o *originReader) Read(p []byte) (int, error) {
+	if o.off == 0 && o.holdsBlobs() {
+		return 0, fmt.Errorf("segment %d: %w", o.baseOffset, ErrBlobRecords)
+	}
 	n, err := o.ReadAt(p, o.off)
 	o.off += int64(n)
 	return n, err
@@ -0,0 +1,91 @@
+package log
+
+import (
+	"errors"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/metrics"
+)
+
+// logMetrics instruments a log registered with Config.Metrics. Its
+// methods do nothing on a nil *logMetrics.
+type logMetrics struct {
+	appends       *metrics.Counter
+	appendErrors  *metrics.Counter
+	appendLatency *metrics.Histogram
+	batchSize     *metrics.Histogram
+	reads         *metrics.CounterVec
+	readLatency   *metrics.Histogram
+	rolls         *metrics.Counter
+}
+
+func newLogMetrics(l *Log, r *metrics.Registry) *logMetrics {
+	r.GaugeFunc("log_segments", "Segments in the log.", func() float64 {
+		return float64(len(l.snapshot()))
+	})
+	r.GaugeFunc("log_next_offset", "Offset the next appended record gets.", func() float64 {
+		segments := l.snapshot()
+		if len(segments) == 0 {
+			// scraped while the log is being set up
+			return 0
+		}
+		return float64(segments[len(segments)-1].NextOffset())
+	})
+	r.GaugeFunc("log_read_only", "Whether low disk space stopped appends.", func() float64 {
+		if l.ReadOnly() {
+			return 1
+		}
+		return 0
+	})
+	return &logMetrics{
+		appends:       r.Counter("log_appends_total", "Records appended."),
+		appendErrors:  r.Counter("log_append_errors_total", "Appends that failed after passing the interceptors."),
+		appendLatency: r.Histogram("log_append_duration_seconds", "Time from queueing an append to its commit.", metrics.DefBuckets),
+		batchSize:     r.Histogram("log_commit_batch_records", "Records written per commit.", []float64{1, 2, 4, 8, 16, 32, 64, 128, 256}),
+		reads:         r.CounterVec("log_reads_total", "Reads by result: ok, out_of_range or error.", "result"),
+		readLatency:   r.Histogram("log_read_duration_seconds", "Time to read a record.", metrics.DefBuckets),
+		rolls:         r.Counter("log_segment_rolls_total", "Segments sealed for a new active one."),
+	}
+}
+
+func (m *logMetrics) committed(batch []*appendRequest) {
+	if m == nil {
+		return
+	}
+	m.batchSize.Observe(float64(len(batch)))
+	for _, req := range batch {
+		if req.err != nil {
+			m.appendErrors.Inc()
+		} else {
+			m.appends.Inc()
+		}
+		m.appendLatency.Since(req.queued)
+	}
+}
+
+func (m *logMetrics) read(start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	switch {
+	case isOutOfRange(err):
+		result = "out_of_range"
+	case err != nil:
+		result = "error"
+	}
+	m.reads.With(result).Inc()
+	m.readLatency.Since(start)
+}
+
+func (m *logMetrics) rolled() {
+	if m == nil {
+		return
+	}
+	m.rolls.Inc()
+}
+
+func isOutOfRange(err error) bool {
+	return errors.As(err, &api.ErrOffsetOutOfRange{})
+}
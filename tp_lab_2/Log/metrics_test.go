@@ -0,0 +1,79 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "metrics-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{Metrics: metrics.NewRegistry()}
+	c.Segment.MaxStoreBytes = 64
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	_, err = l.Read(0)
+	require.NoError(t, err)
+	_, err = l.Read(10)
+	require.Error(t, err)
+	require.NoError(t, l.Roll())
+
+	var b strings.Builder
+	_, err = c.Metrics.WriteTo(&b)
+	require.NoError(t, err)
+	out := b.String()
+	segments, err := l.Segments()
+	require.NoError(t, err)
+	for _, line := range []string{
+		"log_appends_total 5",
+		"log_append_duration_seconds_count 5",
+		`log_reads_total{result="ok"} 1`,
+		`log_reads_total{result="out_of_range"} 1`,
+		"log_next_offset 5",
+		"log_read_only 0",
+		fmt.Sprint("log_segments ", len(segments)),
+		fmt.Sprint("log_segment_rolls_total ", len(segments)-1),
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+}
+
+func TestMetricsReopen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "metrics-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{Metrics: metrics.NewRegistry()}
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	_, err = l.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// the reopened log takes over the metrics of the registry
+	l, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	_, err = l.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	var b strings.Builder
+	_, err = c.Metrics.WriteTo(&b)
+	require.NoError(t, err)
+	require.Contains(t, b.String(), "log_appends_total 2\n")
+	require.Contains(t, b.String(), "log_next_offset 2\n")
+}
@@ -0,0 +1,85 @@
+package log
+
+import (
+	"io"
+	"os"
+)
+
+// Migrate rewrites every segment in dir stored in an older format to the
+// current one and returns the base offsets of the rewritten segments.
+// Like the other offline tools, it must not run while the log is open.
+func Migrate(dir string) ([]uint64, error) {
+	bases, err := segmentBases(dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrated []uint64
+	for _, base := range bases {
+		ok, err := MigrateSegment(dir, base)
+		if err != nil {
+			return migrated, err
+		}
+		if ok {
+			migrated = append(migrated, base)
+		}
+	}
+	return migrated, nil
+}
+
+// MigrateSegment rewrites the segment with the given base offset in the
+// current format, reporting whether it needed migrating. The new store
+// and index are written next to the old ones and renamed over them; if
+// the rename of the index is interrupted, RebuildIndex repairs it.
+func MigrateSegment(dir string, base uint64) (bool, error) {
+	storeName := segmentPath(dir, base, storeExt)
+	f, err := os.Open(storeName)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	version, err := detectFormat(f)
+	if err != nil || version == currentFormat {
+		return false, err
+	}
+
+	var store, index []byte
+	var pos uint64
+	for off := base; ; off++ {
+		p, err := readFrame(f, pos)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		pos += lenWidth + uint64(len(p))
+
+		b := &batch{
+			version:    currentFormat,
+			baseOffset: off,
+			records:    [][]byte{p},
+		}
+		frame := b.encode()
+		entry := make([]byte, entWidth)
+		enc.PutUint32(entry[:offWidth], uint32(off-base))
+		enc.PutUint64(entry[offWidth:], uint64(len(store)))
+		index = append(index, entry...)
+		store = enc.AppendUint64(store, uint64(len(frame)))
+		store = append(store, frame...)
+	}
+
+	indexName := segmentPath(dir, base, indexExt)
+	if err = os.WriteFile(storeName+".tmp", store, 0644); err != nil {
+		return false, err
+	}
+	if err = os.WriteFile(indexName+".tmp", index, 0644); err != nil {
+		return false, err
+	}
+	if err = os.Rename(storeName+".tmp", storeName); err != nil {
+		return false, err
+	}
+	if err = os.Rename(indexName+".tmp", indexName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package log
+
+import (
+	"os"
+	"time"
+)
+
+// Segments describes the segments of the log, oldest first. Unlike the
+// package's Segments function it is safe to call while the log is open.
+func (l *Log) Segments() ([]SegmentInfo, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var infos []SegmentInfo
+	for _, s := range l.snapshot() {
+		info := SegmentInfo{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.NextOffset(),
+			StorePath:  segmentPath(s.dir, s.baseOffset, storeExt),
+			IndexPath:  segmentPath(s.dir, s.baseOffset, indexExt),
+			Sealed:     s.Sealed(),
+		}
+		// the index of the active segment is preallocated, so its size
+		// comes from its entries
+		info.IndexBytes = (info.NextOffset - info.BaseOffset) * entWidth
+		fi, err := os.Stat(info.StorePath)
+		if err != nil {
+			return nil, err
+		}
+		info.StoreBytes = uint64(fi.Size())
+		info.LastModified = fi.ModTime()
+		if s == l.activeSegment {
+			// appends may still sit in the store's buffer
+			info.StoreBytes = s.store.size
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Retention is a policy for removing old segments. Only sealed segments
+// are removed, oldest first, so the log keeps a contiguous offset range.
+type Retention struct {
+	// MaxBytes removes segments while the segments of the log take more
+	// than this. Zero means no limit.
+	MaxBytes uint64
+	// MaxAge removes segments last written to longer ago than this.
+	// Zero means no limit.
+	MaxAge time.Duration
+}
+
+// Expired returns the segments r would remove from the log.
+func (l *Log) Expired(r Retention) ([]SegmentInfo, error) {
+	segments, err := l.Segments()
+	if err != nil {
+		return nil, err
+	}
+	var total uint64
+	for _, s := range segments {
+		total += s.StoreBytes + s.IndexBytes
+	}
+	now := time.Now()
+	var expired []SegmentInfo
+	for _, s := range segments {
+		tooBig := r.MaxBytes > 0 && total > r.MaxBytes
+		tooOld := r.MaxAge > 0 && now.Sub(s.LastModified) > r.MaxAge
+		if !s.Sealed || !(tooBig || tooOld) {
+			break
+		}
+		expired = append(expired, s)
+		total -= s.StoreBytes + s.IndexBytes
+	}
+	return expired, nil
+}
+
+// ApplyRetention removes the segments r expires and returns them.
+func (l *Log) ApplyRetention(r Retention) ([]SegmentInfo, error) {
+	expired, err := l.Expired(r)
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+	if err = l.Truncate(expired[len(expired)-1].NextOffset - 1); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
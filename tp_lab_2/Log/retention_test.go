@@ -0,0 +1,65 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err = l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	segments, err := l.Segments()
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 2)
+	var total uint64
+	for i, s := range segments {
+		total += s.StoreBytes + s.IndexBytes
+		if i > 0 {
+			require.Equal(t, segments[i-1].NextOffset, s.BaseOffset)
+		}
+		require.Equal(t, i < len(segments)-1, s.Sealed)
+	}
+	require.Equal(t, uint64(10), segments[len(segments)-1].NextOffset)
+
+	// nothing is old enough yet
+	expired, err := l.Expired(Retention{MaxAge: time.Hour})
+	require.NoError(t, err)
+	require.Empty(t, expired)
+
+	// the oldest segments go until the rest fit
+	removed, err := l.ApplyRetention(Retention{MaxBytes: total - 1})
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	require.Equal(t, segments[0], removed[0])
+	lowest, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, segments[1].BaseOffset, lowest)
+
+	// the active segment is never removed
+	removed, err = l.ApplyRetention(Retention{MaxBytes: 1})
+	require.NoError(t, err)
+	require.Len(t, removed, len(segments)-2)
+	after, err := l.Segments()
+	require.NoError(t, err)
+	require.Len(t, after, 1)
+	require.Equal(t, segments[len(segments)-1].BaseOffset, after[0].BaseOffset)
+	require.NoError(t, l.Truncate(100))
+	_, err = l.Append(&api.Record{Value: []byte("still here")})
+	require.NoError(t, err)
+}
@@ -4,27 +4,54 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	api "example.com/tpmod/Api/v1"
 
 	"google.golang.org/protobuf/proto"
 )
 
+const (
+	storeExt = ".store"
+	indexExt = ".index"
+)
+
 type segment struct {
+	// mu guards opening and closing the files of sealed segments, which
+	// are opened lazily and closed again when idle. Appends never close
+	// the segment, so the active segment doesn't take it.
+	mu                     sync.RWMutex
 	store                  *store
 	index                  *index
+	sealed                 atomic.Bool
 	baseOffset, nextOffset uint64
-	config                 Config
+	// version is the format the segment's store is written in.
+	version uint8
+	config  Config
+	dir     string
+	// createdAt is when the segment got its first record.
+	createdAt time.Time
+
+	keysMu sync.Mutex
+	keys   *keyIndex
+
+	// blobs is set once the segment is known to hold a blob record;
+	// blobsOnce looks for the ones written before it was opened.
+	blobs     atomic.Bool
+	blobsOnce sync.Once
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	s := &segment{
 		baseOffset: baseOffset,
 		config:     c,
+		dir:        dir,
 	}
 	var err error
 	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
+		segmentPath(dir, baseOffset, storeExt),
 		os.O_RDWR|os.O_CREATE|os.O_APPEND,
 		0644,
 	)
@@ -34,8 +61,11 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	if s.store, err = newStore(storeFile); err != nil {
 		return nil, err
 	}
+	if s.version, err = detectFormat(storeFile); err != nil {
+		return nil, err
+	}
 	indexFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
+		segmentPath(dir, baseOffset, indexExt),
 		os.O_RDWR|os.O_CREATE,
 		0644,
 	)
@@ -45,22 +75,171 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	if s.index, err = newIndex(indexFile, c); err != nil {
 		return nil, err
 	}
+	// an index left at its full size by a crash is padded with zeroes
+	// past its last entry
+	if b := s.index.mmap; uint64(len(b)) >= s.index.size {
+		s.index.size = uint64(len(indexEntries(b[:s.index.size], s.store.size))) * entWidth
+	}
 	if off, _, err := s.index.Read(-1); err != nil {
 		s.nextOffset = baseOffset
 	} else {
 		s.nextOffset = baseOffset + uint64(off) + 1
+		// the log sets the time of the first record when it kept it;
+		// the last write is all the store tells
+		fi, err := storeFile.Stat()
+		if err != nil {
+			return nil, err
+		}
+		s.createdAt = fi.ModTime()
 	}
 
 	return s, nil
 }
 
+// loadSegment returns a sealed segment whose files are opened on first
+// read. Its offset range comes from the size of the index file alone.
+func loadSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
+	s := &segment{
+		baseOffset: baseOffset,
+		nextOffset: baseOffset,
+		config:     c,
+		dir:        dir,
+	}
+	s.sealed.Store(true)
+	f, err := os.Open(segmentPath(dir, baseOffset, indexExt))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(fi.Size()) / entWidth * entWidth
+	if size == 0 {
+		return s, nil
+	}
+	last := make([]byte, entWidth)
+	if _, err = f.ReadAt(last, int64(size-entWidth)); err != nil {
+		return nil, err
+	}
+	if off, pos := readEntry(last, 0); off == 0 && pos == 0 {
+		// the index wasn't closed cleanly and is padded with zeroes
+		var storeSize uint64
+		if fi, err := os.Stat(segmentPath(dir, baseOffset, storeExt)); err == nil {
+			storeSize = uint64(fi.Size())
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		b := make([]byte, size)
+		if _, err = f.ReadAt(b, 0); err != nil {
+			return nil, err
+		}
+		size = uint64(len(indexEntries(b, storeSize))) * entWidth
+	}
+	s.nextOffset = baseOffset + size/entWidth
+	return s, nil
+}
+
+// open opens the files of a sealed segment read-only. Callers must hold
+// s.mu for writing.
+func (s *segment) open() error {
+	storeFile, err := os.Open(segmentPath(s.dir, s.baseOffset, storeExt))
+	if err != nil {
+		return err
+	}
+	st, err := newStore(storeFile)
+	if err != nil {
+		storeFile.Close()
+		return err
+	}
+	st.sealed.Store(true)
+	if s.version, err = detectFormat(storeFile); err != nil {
+		st.Close()
+		return err
+	}
+	indexFile, err := os.Open(segmentPath(s.dir, s.baseOffset, indexExt))
+	if err != nil {
+		st.Close()
+		return err
+	}
+	idx, err := openIndex(indexFile, st.size)
+	if err != nil {
+		st.Close()
+		indexFile.Close()
+		return err
+	}
+	s.store, s.index = st, idx
+	return nil
+}
+
+// acquire makes sure the segment's files are open and returns with s.mu
+// held for reading; callers must release it with s.mu.RUnlock.
+func (s *segment) acquire() error {
+	for {
+		s.mu.RLock()
+		if s.store != nil {
+			return nil
+		}
+		s.mu.RUnlock()
+		s.mu.Lock()
+		var err error
+		if s.store == nil {
+			err = s.open()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// IsOpen reports whether the segment's files are open.
+func (s *segment) IsOpen() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store != nil
+}
+
 func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 	cur := s.nextOffset
+	if cur == s.baseOffset {
+		s.createdAt = time.Now()
+	}
 	record.Offset = cur
+	var attrs uint16
+	value := record.Value
+	if max := s.config.Segment.MaxRecordBytes; s.version != formatV1 &&
+		max > 0 && uint64(len(value)) > max {
+		if err := writeBlob(s.dir, cur, value); err != nil {
+			return 0, err
+		}
+		attrs |= attrBlob
+		s.blobs.Store(true)
+		record.Value = nil
+		defer func() {
+			record.Value = value
+			if err != nil {
+				os.Remove(blobPath(s.dir, cur))
+			}
+		}()
+	}
 	p, err := proto.Marshal(record)
 	if err != nil {
 		return 0, err
 	}
+	if s.version != formatV1 {
+		b := &batch{
+			version:    s.version,
+			attributes: attrs,
+			baseOffset: cur,
+			records:    [][]byte{p},
+		}
+		p = b.encode()
+	}
 	_, pos, err := s.store.Append(p)
 	if err != nil {
 		return 0, err
@@ -73,11 +252,16 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 	); err != nil {
 		return 0, err
 	}
-	s.nextOffset++
+	s.appendKey(cur, record.Key)
+	atomic.AddUint64(&s.nextOffset, 1)
 	return cur, nil
 }
 
 func (s *segment) Read(off uint64) (*api.Record, error) {
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
 	_, pos, err := s.index.Read(int64(off - s.baseOffset))
 	if err != nil {
 		return nil, err
@@ -86,30 +270,123 @@ func (s *segment) Read(off uint64) (*api.Record, error) {
 	if err != nil {
 		return nil, err
 	}
+	p, attrs, err := decodeRecord(s.version, p, off)
+	if err != nil {
+		return nil, err
+	}
 	record := &api.Record{}
-	err = proto.Unmarshal(p, record)
-	return record, err
+	if err = proto.Unmarshal(p, record); err != nil {
+		return nil, err
+	}
+	if attrs&attrBlob != 0 {
+		if record.Value, err = readBlob(s.dir, off); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// holdsBlobs reports whether some of the segment's records keep their
+// value in a blob file.
+func (s *segment) holdsBlobs() bool {
+	s.blobsOnce.Do(func() {
+		if hasBlobs(s.dir, s.baseOffset, s.NextOffset()) {
+			s.blobs.Store(true)
+		}
+	})
+	return s.blobs.Load()
+}
+
+// ReadAt reads the segment's store file from off.
+func (s *segment) ReadAt(p []byte, off int64) (int, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.mu.RUnlock()
+	return s.store.ReadAt(p, off)
+}
+
+// Sync flushes the segment's store and commits it and the index to stable
+// storage. The store goes first, so that a synced entry never points past
+// the records on disk.
+func (s *segment) Sync() error {
+	if err := s.flushKeys(); err != nil {
+		return err
+	}
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
+// NextOffset returns the offset the next appended record will get. It is
+// safe to call while the segment is being appended to.
+func (s *segment) NextOffset() uint64 {
+	return atomic.LoadUint64(&s.nextOffset)
+}
+
+// Seal flushes the segment and marks it read-only, after which it can be
+// read without taking the store's lock.
+func (s *segment) Seal() error {
+	if err := s.store.seal(); err != nil {
+		return err
+	}
+	if err := s.closeKeys(); err != nil {
+		return err
+	}
+	s.sealed.Store(true)
+	return nil
+}
+
+func (s *segment) Sealed() bool {
+	return s.sealed.Load()
 }
 
 func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes || s.index.size >= s.config.Segment.MaxIndexBytes
 }
 
+// IsExpired reports whether the segment holds records older than
+// MaxSegmentAge.
+func (s *segment) IsExpired(now time.Time) bool {
+	age := s.config.Segment.MaxSegmentAge
+	if age <= 0 || s.nextOffset == s.baseOffset {
+		return false
+	}
+	return now.Sub(s.createdAt) >= age
+}
+
 func (s *segment) Remove() error {
 	if err := s.Close(); err != nil {
 		return err
 	}
-	if err := os.Remove(s.index.Name()); err != nil {
+	if err := os.Remove(segmentPath(s.dir, s.baseOffset, indexExt)); err != nil {
 		return err
 	}
 
-	if err := os.Remove(s.store.Name()); err != nil {
+	if err := os.Remove(segmentPath(s.dir, s.baseOffset, storeExt)); err != nil {
 		return err
 	}
-	return nil
+	if err := os.Remove(segmentPath(s.dir, s.baseOffset, keysExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return removeBlobs(s.dir, s.baseOffset, s.NextOffset())
 }
 
+// Close closes the segment's files and drops its key index. A sealed
+// segment reopens them on its next read.
 func (s *segment) Close() error {
+	if err := s.closeKeys(); err != nil {
+		return err
+	}
+	// the key index is loaded again on next use, so that segments closed
+	// when idle don't keep it in memory
+	s.dropKeys()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		return nil
+	}
 	if err := s.index.Close(); err != nil {
 		return err
 	}
@@ -117,9 +394,15 @@ func (s *segment) Close() error {
 	if err := s.store.Close(); err != nil {
 		return err
 	}
+	s.store, s.index = nil, nil
 	return nil
 }
 
+// segmentPath returns the path of the segment file with the given extension.
+func segmentPath(dir string, baseOffset uint64, ext string) string {
+	return path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ext))
+}
+
 func nearestMultiple(j, k uint64) uint64 {
 	if j >= 0 {
 		return (j / k) * k
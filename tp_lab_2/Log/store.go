@@ -3,8 +3,11 @@ package log
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -15,11 +18,16 @@ const (
 	lenWidth = 8
 )
 
+var errSealed = errors.New("store is sealed")
+
 type store struct {
 	*os.File
 	mu   sync.Mutex
 	buf  *bufio.Writer
 	size uint64
+	// sealed stores accept no more appends and have nothing buffered, so
+	// they are read without taking mu.
+	sealed atomic.Bool
 }
 
 func newStore(f *os.File) (*store, error) {
@@ -38,6 +46,9 @@ func newStore(f *os.File) (*store, error) {
 func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if s.sealed.Load() {
+		return 0, 0, errSealed
+	}
 	pos = s.size
 	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
 		return 0, 0, err
@@ -53,23 +64,34 @@ func (s *store) Append(p []byte) (n uint64, pos uint64, err error) {
 }
 
 func (s *store) Read(pos uint64) ([]byte, error) {
+	if s.sealed.Load() {
+		return readFrame(s.File, pos)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.buf.Flush(); err != nil {
 		return nil, err
 	}
+	return readFrame(s.File, pos)
+}
+
+// readFrame reads the length-prefixed record stored at pos.
+func readFrame(r io.ReaderAt, pos uint64) ([]byte, error) {
 	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
+	if _, err := r.ReadAt(size, int64(pos)); err != nil {
 		return nil, err
 	}
 	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
+	if _, err := r.ReadAt(b, int64(pos+lenWidth)); err != nil {
 		return nil, err
 	}
 	return b, nil
 }
 
 func (s *store) ReadAt(p []byte, off int64) (int, error) {
+	if s.sealed.Load() {
+		return s.File.ReadAt(p, off)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.buf.Flush(); err != nil {
@@ -78,6 +100,27 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// Sync flushes buffered writes and commits the file to stable storage.
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.File.Sync()
+}
+
+// seal flushes any buffered writes and stops further appends.
+func (s *store) seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	s.sealed.Store(true)
+	return nil
+}
+
 func (s *store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,217 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/merkle"
+)
+
+// treeFile holds the leaf hashes of the log's Merkle tree after a header
+// with the offset of the first leaf. Leaves lost in a crash are rebuilt
+// from the segments, which truncation removes, so the file is synced with
+// the store on Commit.Sync and whenever a segment is sealed.
+const (
+	treeFile        = "merkle.leaves"
+	treeHeaderWidth = 8
+)
+
+var (
+	// ErrNoTree is returned by the tree methods of logs that do not keep
+	// a Merkle tree.
+	ErrNoTree = errors.New("log has no merkle tree")
+	// ErrNoSigningKey is returned by TreeHead when no key signs them.
+	ErrNoSigningKey = errors.New("log has no tree head signing key")
+)
+
+// recordTree covers every record appended to the log with a leaf of a
+// Merkle tree. The leaf for offset off is the (off-first)-th one.
+type recordTree struct {
+	file  *os.File
+	first uint64
+	tree  merkle.Tree
+	// err is the error that left the tree behind the log; appends fail
+	// until the log is reopened and the missing leaves rebuilt.
+	err error
+}
+
+// openTree loads the tree file of the log and adds leaves for the records
+// appended since it was last written.
+func (l *Log) openTree() error {
+	f, err := os.OpenFile(filepath.Join(l.Dir, treeFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	segments := l.snapshot()
+	next := segments[len(segments)-1].NextOffset()
+	t := &recordTree{file: f, first: segments[0].baseOffset}
+	if len(b) >= treeHeaderWidth {
+		t.first = enc.Uint64(b)
+		leaves := b[treeHeaderWidth:]
+		n := uint64(len(leaves) / merkle.HashSize)
+		switch {
+		case t.first > next:
+			n = 0
+		case t.first+n > next:
+			// the records of these leaves were lost in a torn write
+			n = next - t.first
+		}
+		for i := uint64(0); i < n; i++ {
+			t.tree.Append(leaves[i*merkle.HashSize : (i+1)*merkle.HashSize])
+		}
+	}
+	if err = t.rewind(); err != nil {
+		f.Close()
+		return err
+	}
+	for off := t.first + t.tree.Size(); off < next; off++ {
+		record, err := l.read(off)
+		if err == nil {
+			err = t.append(record)
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("adding offset %d to the merkle tree: %w", off, err)
+		}
+	}
+	l.tree = t
+	return nil
+}
+
+// rewind truncates the file to the leaves in the tree.
+func (t *recordTree) rewind() error {
+	size := int64(treeHeaderWidth) + int64(t.tree.Size())*merkle.HashSize
+	if err := t.file.Truncate(size); err != nil {
+		return err
+	}
+	header := make([]byte, treeHeaderWidth)
+	enc.PutUint64(header, t.first)
+	if _, err := t.file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	_, err := t.file.Seek(size, io.SeekStart)
+	return err
+}
+
+// append adds the leaf of a record already written to the log. On failure
+// the tree is left behind the log, which t.err records.
+func (t *recordTree) append(record *api.Record) error {
+	if t.err != nil {
+		return t.err
+	}
+	leaf, err := merkle.RecordLeaf(record)
+	if err == nil {
+		if _, err = t.file.Write(leaf); err != nil {
+			// drop a partly written leaf so the file stays aligned
+			_ = t.rewind()
+		}
+	}
+	if err != nil {
+		t.err = fmt.Errorf("merkle tree missing offset %d: %w", record.Offset, err)
+		return t.err
+	}
+	t.tree.Append(leaf)
+	return nil
+}
+
+// sync commits the leaves to stable storage.
+func (t *recordTree) sync() error {
+	return t.file.Sync()
+}
+
+// startSigner signs a tree head every interval.
+func (l *Log) startSigner(interval time.Duration) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = l.signTreeHead()
+			}
+		}
+	}()
+	l.stopSigner = func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (l *Log) signTreeHead() error {
+	l.mu.RLock()
+	size := l.tree.tree.Size()
+	root, err := l.tree.tree.Root(size)
+	l.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	head := &merkle.TreeHead{
+		Size:      size,
+		Timestamp: time.Now().UnixNano(),
+		Root:      root,
+	}
+	head.Sign(l.Config.Merkle.SigningKey)
+	l.treeHead.Store(head)
+	return nil
+}
+
+// TreeHead returns the latest signed tree head. Heads are signed every
+// Merkle.TreeHeadInterval or, when it is zero, on every call.
+func (l *Log) TreeHead() (*merkle.TreeHead, error) {
+	if l.tree == nil {
+		return nil, ErrNoTree
+	}
+	if l.Config.Merkle.SigningKey == nil {
+		return nil, ErrNoSigningKey
+	}
+	if l.Config.Merkle.TreeHeadInterval == 0 {
+		if err := l.signTreeHead(); err != nil {
+			return nil, err
+		}
+	}
+	return l.treeHead.Load(), nil
+}
+
+// InclusionProof returns the leaf index of the record at off and its audit
+// path in the tree made of the first size leaves.
+func (l *Log) InclusionProof(off, size uint64) (uint64, [][]byte, error) {
+	if l.tree == nil {
+		return 0, nil, ErrNoTree
+	}
+	if off < l.tree.first {
+		return 0, nil, fmt.Errorf("%w: offset %d precedes the tree", merkle.ErrInvalidRange, off)
+	}
+	index := off - l.tree.first
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	proof, err := l.tree.tree.InclusionProof(index, size)
+	return index, proof, err
+}
+
+// ConsistencyProof proves the tree of size1 leaves is a prefix of the tree
+// of size2 leaves.
+func (l *Log) ConsistencyProof(size1, size2 uint64) ([][]byte, error) {
+	if l.tree == nil {
+		return nil, ErrNoTree
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tree.tree.ConsistencyProof(size1, size2)
+}
@@ -0,0 +1,126 @@
+package log
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"example.com/tpmod/merkle"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTree(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tree-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	c.Segment.MaxRecordBytes = 16
+	c.Merkle.Enabled = true
+	c.Merkle.SigningKey = key
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	values := [][]byte{
+		[]byte("first"),
+		bytes.Repeat([]byte("blob"), 8),
+		[]byte("third"),
+	}
+	for _, v := range values {
+		_, err := l.Append(&api.Record{Value: v})
+		require.NoError(t, err)
+	}
+	head1, err := l.TreeHead()
+	require.NoError(t, err)
+	require.NoError(t, head1.Verify(pub))
+	require.Equal(t, uint64(3), head1.Size)
+
+	for _, v := range values {
+		_, err := l.Append(&api.Record{Value: v})
+		require.NoError(t, err)
+	}
+	head2, err := l.TreeHead()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), head2.Size)
+
+	// every record read back checks against the signed root
+	for off := uint64(0); off < head2.Size; off++ {
+		record, err := l.Read(off)
+		require.NoError(t, err)
+		leaf, err := merkle.RecordLeaf(record)
+		require.NoError(t, err)
+		index, proof, err := l.InclusionProof(off, head2.Size)
+		require.NoError(t, err)
+		require.NoError(t, merkle.VerifyInclusion(leaf, index, head2.Size, proof, head2.Root))
+
+		record.Value = []byte("tampered")
+		leaf, err = merkle.RecordLeaf(record)
+		require.NoError(t, err)
+		require.Error(t, merkle.VerifyInclusion(leaf, index, head2.Size, proof, head2.Root))
+	}
+	proof, err := l.ConsistencyProof(head1.Size, head2.Size)
+	require.NoError(t, err)
+	require.NoError(t, merkle.VerifyConsistency(head1.Size, head2.Size, proof, head1.Root, head2.Root))
+	require.NoError(t, l.Close())
+
+	// leaves missing from the tree file are rebuilt from the segments
+	name := filepath.Join(dir, treeFile)
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(name, fi.Size()-merkle.HashSize-5))
+	l, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	head3, err := l.TreeHead()
+	require.NoError(t, err)
+	require.Equal(t, head2.Root, head3.Root)
+
+	_, _, err = l.InclusionProof(6, 6)
+	require.ErrorIs(t, err, merkle.ErrInvalidRange)
+}
+
+func TestTreeWriteFailure(t *testing.T) {
+	dir, err := os.MkdirTemp("", "tree-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Merkle.Enabled = true
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	_, err = l.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+
+	// a read-only handle fails the next leaf write
+	file := l.tree.file
+	l.tree.file, err = os.Open(file.Name())
+	require.NoError(t, err)
+
+	// the record is written, so its append succeeds
+	off, err := l.Append(&api.Record{Value: []byte("second")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	// later ones fail before writing theirs
+	_, err = l.Append(&api.Record{Value: []byte("third")})
+	require.Error(t, err)
+	off, err = l.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	require.NoError(t, l.Close())
+	require.NoError(t, file.Close())
+
+	// reopening adds the missing leaf
+	l, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, uint64(2), l.tree.tree.Size())
+	off, err = l.Append(&api.Record{Value: []byte("third")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+}
@@ -0,0 +1,39 @@
+package log
+
+import (
+	"context"
+
+	api "example.com/tpmod/Api/v1"
+)
+
+// Wait blocks until the record at off is appended or ctx is done. It
+// returns right away for offsets already in the log, and with
+// ErrOffsetOutOfRange for ones truncated away, which no append brings
+// back.
+func (l *Log) Wait(ctx context.Context, off uint64) error {
+	for {
+		// load the channel before checking the offset so an append in
+		// between still wakes us
+		appended := l.appended.Load()
+		segments := l.snapshot()
+		if off < segments[0].baseOffset {
+			return api.ErrOffsetOutOfRange{Offset: off}
+		}
+		if segments[len(segments)-1].NextOffset() > off {
+			return nil
+		}
+		select {
+		case <-*appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notifyAppended wakes the callers of Wait; callers must hold mu.
+func (l *Log) notifyAppended() {
+	appended := make(chan struct{})
+	if old := l.appended.Swap(&appended); old != nil {
+		close(*old)
+	}
+}
@@ -0,0 +1,47 @@
+package log
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWait(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.Wait(ctx, 0), context.DeadlineExceeded)
+
+	woken := make(chan error)
+	go func() {
+		woken <- l.Wait(context.Background(), 1)
+	}()
+	_, err = l.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	select {
+	case <-woken:
+		t.Fatal("woken before offset 1 was appended")
+	case <-time.After(10 * time.Millisecond):
+	}
+	_, err = l.Append(&api.Record{Value: []byte("second")})
+	require.NoError(t, err)
+	require.NoError(t, <-woken)
+
+	require.NoError(t, l.Wait(context.Background(), 0))
+
+	// truncated offsets are never appended again
+	require.NoError(t, l.Roll())
+	require.NoError(t, l.Truncate(1))
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: 1}, l.Wait(context.Background(), 1))
+}
@@ -0,0 +1,57 @@
+package log
+
+import (
+	"sync/atomic"
+	"time"
+
+	api "example.com/tpmod/Api/v1"
+)
+
+// diskFree measures the free space of a directory; tests replace it.
+var diskFree = freeSpace
+
+// diskGuard tracks whether low disk space put the log in read-only mode.
+type diskGuard struct {
+	readOnly atomic.Bool
+	free     uint64
+	checked  time.Time
+}
+
+// checkDisk returns api.ErrDiskFull while the log is read-only. The log
+// turns read-only when the free space of the active segment's directory
+// drops below the low watermark and stays so until it reaches the high
+// watermark again. Callers must hold mu.
+func (l *Log) checkDisk() error {
+	c := l.Config.Disk
+	if c.LowWatermark == 0 {
+		return nil
+	}
+	dir := l.activeSegment.dir
+	now := time.Now()
+	if l.disk.checked.IsZero() || now.Sub(l.disk.checked) >= c.CheckInterval {
+		// when free space can't be measured, keep the previous state
+		if free, err := diskFree(dir); err == nil {
+			l.disk.free, l.disk.checked = free, now
+			high := c.HighWatermark
+			if high < c.LowWatermark {
+				high = c.LowWatermark
+			}
+			switch {
+			case free < c.LowWatermark:
+				l.disk.readOnly.Store(true)
+			case free >= high:
+				l.disk.readOnly.Store(false)
+			}
+		}
+	}
+	if l.disk.readOnly.Load() {
+		return api.ErrDiskFull{Dir: dir, Free: l.disk.free}
+	}
+	return nil
+}
+
+// ReadOnly reports whether the log is rejecting appends for lack of disk
+// space.
+func (l *Log) ReadOnly() bool {
+	return l.disk.readOnly.Load()
+}
@@ -0,0 +1,54 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDiskWatermarks(t *testing.T) {
+	dir, err := os.MkdirTemp("", "watermark-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	free := uint64(1000)
+	diskFree = func(string) (uint64, error) { return free, nil }
+	defer func() { diskFree = freeSpace }()
+
+	c := Config{}
+	c.Disk.LowWatermark = 100
+	c.Disk.HighWatermark = 200
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	append := &api.Record{Value: []byte("hello world")}
+	_, err = log.Append(append)
+	require.NoError(t, err)
+
+	free = 50
+	_, err = log.Append(append)
+	var diskErr api.ErrDiskFull
+	require.ErrorAs(t, err, &diskErr)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.True(t, log.ReadOnly())
+	// reads keep working while appends are rejected
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+
+	// appends stay rejected until the high watermark is reached
+	free = 150
+	_, err = log.Append(append)
+	require.ErrorAs(t, err, &diskErr)
+
+	free = 250
+	off, err := log.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	require.False(t, log.ReadOnly())
+}
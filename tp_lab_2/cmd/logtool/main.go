@@ -0,0 +1,169 @@
+// logtool inspects and repairs the segment files of a log directory.
+// The log must not be open by a server while the tool runs.
+//
+// Usage:
+//
+//	logtool segments -dir DIR
+//	logtool dump -dir DIR [-from OFF] [-to OFF]
+//	logtool verify -dir DIR
+//	logtool reindex -dir DIR -base OFF
+//	logtool gaps -dir DIR
+//	logtool migrate -dir DIR
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+
+	api "example.com/tpmod/Api/v1"
+	log "example.com/tpmod/Log"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	commands := map[string]func(args []string) error{
+		"segments": segments,
+		"dump":     dump,
+		"verify":   verify,
+		"reindex":  reindex,
+		"gaps":     gaps,
+		"migrate":  migrate,
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "logtool %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: logtool segments|dump|verify|reindex|gaps|migrate -dir DIR [flags]")
+	os.Exit(2)
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	dir := fs.String("dir", "", "log directory")
+	return fs, dir
+}
+
+func requireDir(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	return nil
+}
+
+func segments(args []string) error {
+	fs, dir := newFlagSet("segments")
+	fs.Parse(args)
+	if err := requireDir(*dir); err != nil {
+		return err
+	}
+	infos, err := log.Segments(*dir)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BASE\tNEXT\tRECORDS\tSTORE BYTES\tINDEX BYTES")
+	for _, s := range infos {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\n",
+			s.BaseOffset, s.NextOffset, s.Records(), s.StoreBytes, s.IndexBytes)
+	}
+	return w.Flush()
+}
+
+func dump(args []string) error {
+	fs, dir := newFlagSet("dump")
+	from := fs.Uint64("from", 0, "first offset to dump")
+	to := fs.Uint64("to", math.MaxUint64, "offset to stop at (exclusive)")
+	fs.Parse(args)
+	if err := requireDir(*dir); err != nil {
+		return err
+	}
+	out := json.NewEncoder(os.Stdout)
+	return log.Dump(*dir, *from, *to, func(record *api.Record) error {
+		return out.Encode(record)
+	})
+}
+
+func verify(args []string) error {
+	fs, dir := newFlagSet("verify")
+	fs.Parse(args)
+	if err := requireDir(*dir); err != nil {
+		return err
+	}
+	problems, err := log.Verify(*dir)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Printf("segment %d offset %d: %s\n", p.BaseOffset, p.Offset, p.Reason)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func reindex(args []string) error {
+	fs, dir := newFlagSet("reindex")
+	base := fs.Uint64("base", 0, "base offset of the segment to reindex")
+	fs.Parse(args)
+	if err := requireDir(*dir); err != nil {
+		return err
+	}
+	n, err := log.RebuildIndex(*dir, *base)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("segment %d: indexed %d records\n", *base, n)
+	return nil
+}
+
+func gaps(args []string) error {
+	fs, dir := newFlagSet("gaps")
+	fs.Parse(args)
+	if err := requireDir(*dir); err != nil {
+		return err
+	}
+	found, err := log.Gaps(*dir)
+	if err != nil {
+		return err
+	}
+	for _, g := range found {
+		fmt.Printf("missing offsets [%d, %d)\n", g.From, g.To)
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("%d gaps found", len(found))
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func migrate(args []string) error {
+	fs, dir := newFlagSet("migrate")
+	fs.Parse(args)
+	if err := requireDir(*dir); err != nil {
+		return err
+	}
+	migrated, err := log.Migrate(*dir)
+	for _, base := range migrated {
+		fmt.Printf("segment %d: migrated\n", base)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d segments migrated\n", len(migrated))
+	return nil
+}
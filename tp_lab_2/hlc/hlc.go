@@ -0,0 +1,104 @@
+// Package hlc implements hybrid logical clocks, which order events across
+// hosts consistently with causality while staying close to wall time.
+package hlc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock reading: the largest wall time seen,
+// in Unix nanoseconds, and a counter ordering events within it.
+type Timestamp struct {
+	WallTime int64
+	Logical  uint32
+}
+
+// Less reports whether t happened before u.
+func (t Timestamp) Less(u Timestamp) bool {
+	return t.WallTime < u.WallTime ||
+		(t.WallTime == u.WallTime && t.Logical < u.Logical)
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d", t.WallTime, t.Logical)
+}
+
+// SkewError is returned by Update for timestamps further ahead of the
+// local wall clock than the clock's maximum offset.
+type SkewError struct {
+	Remote Timestamp
+	Skew   time.Duration
+}
+
+func (e *SkewError) Error() string {
+	return fmt.Sprintf("hlc: remote timestamp %s is %s ahead", e.Remote, e.Skew)
+}
+
+// Clock is a hybrid logical clock. It is safe for concurrent use.
+type Clock struct {
+	mu        sync.Mutex
+	physical  func() int64
+	maxOffset time.Duration
+	last      Timestamp
+}
+
+// NewClock returns a clock reading the wall time from physical, or from
+// time.Now when physical is nil. Update rejects timestamps more than
+// maxOffset ahead of the wall time; zero accepts any.
+func NewClock(physical func() int64, maxOffset time.Duration) *Clock {
+	if physical == nil {
+		physical = func() int64 { return time.Now().UnixNano() }
+	}
+	return &Clock{physical: physical, maxOffset: maxOffset}
+}
+
+// Now returns a timestamp for a local event, later than every timestamp
+// the clock returned or was updated with before.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt := c.physical(); pt > c.last.WallTime {
+		c.last = Timestamp{WallTime: pt}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update returns a timestamp for an event caused by one stamped remote,
+// later than both remote and every timestamp the clock returned before.
+func (c *Clock) Update(remote Timestamp) (Timestamp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.physical()
+	if c.maxOffset > 0 && remote.WallTime > pt+int64(c.maxOffset) {
+		return Timestamp{}, &SkewError{
+			Remote: remote,
+			Skew:   time.Duration(remote.WallTime - pt),
+		}
+	}
+	last := c.last
+	switch wall := max(pt, last.WallTime, remote.WallTime); {
+	case wall == last.WallTime && wall == remote.WallTime:
+		c.last = Timestamp{WallTime: wall, Logical: max(last.Logical, remote.Logical) + 1}
+	case wall == last.WallTime:
+		c.last = Timestamp{WallTime: wall, Logical: last.Logical + 1}
+	case wall == remote.WallTime:
+		c.last = Timestamp{WallTime: wall, Logical: remote.Logical + 1}
+	default:
+		c.last = Timestamp{WallTime: wall}
+	}
+	return c.last, nil
+}
+
+// Restore moves the clock forward to ts, without checking its offset, so
+// a restarted process does not stamp events before ones it persisted.
+func (c *Clock) Restore(ts Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last.Less(ts) {
+		c.last = ts
+	}
+}
@@ -0,0 +1,57 @@
+package hlc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	var wall int64 = 100
+	c := NewClock(func() int64 { return wall }, 50)
+
+	require.Equal(t, Timestamp{WallTime: 100}, c.Now())
+	require.Equal(t, Timestamp{WallTime: 100, Logical: 1}, c.Now())
+
+	// the wall clock going back does not move the clock back
+	wall = 90
+	require.Equal(t, Timestamp{WallTime: 100, Logical: 2}, c.Now())
+
+	wall = 110
+	require.Equal(t, Timestamp{WallTime: 110}, c.Now())
+
+	// a remote clock ahead is followed
+	ts, err := c.Update(Timestamp{WallTime: 120, Logical: 4})
+	require.NoError(t, err)
+	require.Equal(t, Timestamp{WallTime: 120, Logical: 5}, ts)
+	ts, err = c.Update(Timestamp{WallTime: 120, Logical: 9})
+	require.NoError(t, err)
+	require.Equal(t, Timestamp{WallTime: 120, Logical: 10}, ts)
+	ts, err = c.Update(Timestamp{WallTime: 50})
+	require.NoError(t, err)
+	require.Equal(t, Timestamp{WallTime: 120, Logical: 11}, ts)
+	wall = 200
+	ts, err = c.Update(Timestamp{WallTime: 150, Logical: 3})
+	require.NoError(t, err)
+	require.Equal(t, Timestamp{WallTime: 200}, ts)
+
+	_, err = c.Update(Timestamp{WallTime: 251})
+	var skew *SkewError
+	require.ErrorAs(t, err, &skew)
+	require.Equal(t, time.Duration(51), skew.Skew)
+	require.Equal(t, Timestamp{WallTime: 200, Logical: 1}, c.Now())
+
+	c.Restore(Timestamp{WallTime: 300})
+	require.Equal(t, Timestamp{WallTime: 300, Logical: 1}, c.Now())
+	c.Restore(Timestamp{WallTime: 10})
+	require.Equal(t, Timestamp{WallTime: 300, Logical: 2}, c.Now())
+}
+
+func TestClockWallTime(t *testing.T) {
+	c := NewClock(nil, time.Second)
+	before := time.Now().UnixNano()
+	ts := c.Now()
+	require.GreaterOrEqual(t, ts.WallTime, before)
+	require.True(t, ts.Less(c.Now()))
+}
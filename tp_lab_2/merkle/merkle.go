@@ -0,0 +1,179 @@
+// Package merkle implements the Merkle tree of RFC 6962 used to make the
+// log tamper-evident, along with the checks a client runs offline on the
+// tree heads and proofs the server hands out.
+package merkle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	api "example.com/tpmod/Api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// HashSize is the size of every hash in the tree.
+const HashSize = sha256.Size
+
+var (
+	// ErrInvalidRange is returned for proofs asked for tree sizes or
+	// leaves the tree does not cover.
+	ErrInvalidRange = errors.New("merkle: invalid range")
+	// ErrInvalidProof is returned when a proof does not check out.
+	ErrInvalidProof = errors.New("merkle: invalid proof")
+)
+
+// LeafHash returns the hash of the leaf holding data.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// NodeHash returns the hash of the interior node with children l and r.
+func NodeHash(l, r []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(l)
+	h.Write(r)
+	return h.Sum(nil)
+}
+
+// EmptyRoot is the root hash of the empty tree.
+func EmptyRoot() []byte {
+	h := sha256.Sum256(nil)
+	return h[:]
+}
+
+// RecordLeaf returns the leaf hash of record, which covers its offset and
+// every other field.
+func RecordLeaf(record *api.Record) ([]byte, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return LeafHash(b), nil
+}
+
+// TreeHead commits to the first Size leaves of the tree.
+type TreeHead struct {
+	Size uint64
+	// Timestamp is when the head was signed, in Unix nanoseconds.
+	Timestamp int64
+	Root      []byte
+	Signature []byte
+}
+
+// signed returns the bytes covered by the signature.
+func (h *TreeHead) signed() []byte {
+	b := make([]byte, 16, 16+len(h.Root))
+	binary.BigEndian.PutUint64(b, h.Size)
+	binary.BigEndian.PutUint64(b[8:], uint64(h.Timestamp))
+	return append(b, h.Root...)
+}
+
+// Sign signs the head with key.
+func (h *TreeHead) Sign(key ed25519.PrivateKey) {
+	h.Signature = ed25519.Sign(key, h.signed())
+}
+
+// Verify checks the head's signature with the server's public key.
+func (h *TreeHead) Verify(pub ed25519.PublicKey) error {
+	if !ed25519.Verify(pub, h.signed(), h.Signature) {
+		return fmt.Errorf("%w: bad tree head signature", ErrInvalidProof)
+	}
+	return nil
+}
+
+// VerifyInclusion checks that leaf is the index-th leaf of the tree of the
+// given size with the given root (RFC 9162, section 2.1.3.2).
+func VerifyInclusion(leaf []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return fmt.Errorf("%w: leaf %d of tree size %d", ErrInvalidRange, index, size)
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			r = NodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = NodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: proof too short", ErrInvalidProof)
+	}
+	if !bytes.Equal(r, root) {
+		return fmt.Errorf("%w: root mismatch", ErrInvalidProof)
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size1 with root1 is a prefix
+// of the tree of size2 with root2 (RFC 9162, section 2.1.4.2).
+func VerifyConsistency(size1, size2 uint64, proof [][]byte, root1, root2 []byte) error {
+	switch {
+	case size1 > size2:
+		return fmt.Errorf("%w: tree size %d is larger than %d", ErrInvalidRange, size1, size2)
+	case size1 == size2:
+		if len(proof) > 0 || !bytes.Equal(root1, root2) {
+			return fmt.Errorf("%w: trees of equal size differ", ErrInvalidProof)
+		}
+		return nil
+	case size1 == 0:
+		if len(proof) > 0 {
+			return fmt.Errorf("%w: proof too long", ErrInvalidProof)
+		}
+		return nil
+	}
+	if bits.OnesCount64(size1) == 1 {
+		proof = append([][]byte{root1}, proof...)
+	}
+	if len(proof) == 0 {
+		return fmt.Errorf("%w: empty proof", ErrInvalidProof)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%w: proof too long", ErrInvalidProof)
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = NodeHash(c, fr)
+			sr = NodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = NodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("%w: proof too short", ErrInvalidProof)
+	}
+	if !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return fmt.Errorf("%w: root mismatch", ErrInvalidProof)
+	}
+	return nil
+}
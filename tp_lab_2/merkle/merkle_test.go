@@ -0,0 +1,78 @@
+package merkle
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rootOf computes MTH straight from RFC 6962 as a reference.
+func rootOf(leaves [][]byte) []byte {
+	n := uint64(len(leaves))
+	if n == 0 {
+		return EmptyRoot()
+	}
+	if n == 1 {
+		return leaves[0]
+	}
+	k := split(n)
+	return NodeHash(rootOf(leaves[:k]), rootOf(leaves[k:]))
+}
+
+func TestTree(t *testing.T) {
+	var tree Tree
+	var leaves [][]byte
+	for i := 0; i < 33; i++ {
+		leaf := LeafHash([]byte(fmt.Sprintf("record %d", i)))
+		tree.Append(leaf)
+		leaves = append(leaves, leaf)
+	}
+
+	for size := uint64(0); size <= tree.Size(); size++ {
+		root, err := tree.Root(size)
+		require.NoError(t, err)
+		require.Equal(t, rootOf(leaves[:size]), root, "size %d", size)
+
+		for i := uint64(0); i < size; i++ {
+			proof, err := tree.InclusionProof(i, size)
+			require.NoError(t, err)
+			require.NoError(t, VerifyInclusion(leaves[i], i, size, proof, root),
+				"leaf %d of %d", i, size)
+			if len(proof) > 0 {
+				require.ErrorIs(t, VerifyInclusion(leaves[i], i, size, proof[1:], root), ErrInvalidProof)
+			}
+			other := LeafHash([]byte("tampered"))
+			require.ErrorIs(t, VerifyInclusion(other, i, size, proof, root), ErrInvalidProof)
+		}
+
+		for size1 := uint64(0); size1 <= size; size1++ {
+			proof, err := tree.ConsistencyProof(size1, size)
+			require.NoError(t, err)
+			root1, err := tree.Root(size1)
+			require.NoError(t, err)
+			require.NoError(t, VerifyConsistency(size1, size, proof, root1, root),
+				"sizes %d and %d", size1, size)
+			if size1 > 0 && size1 < size {
+				require.Error(t, VerifyConsistency(size1, size, proof, root, root))
+			}
+		}
+	}
+
+	_, err := tree.InclusionProof(5, 34)
+	require.ErrorIs(t, err, ErrInvalidRange)
+	_, err = tree.ConsistencyProof(3, 2)
+	require.ErrorIs(t, err, ErrInvalidRange)
+}
+
+func TestTreeHead(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	head := &TreeHead{Size: 3, Timestamp: 42, Root: LeafHash([]byte("root"))}
+	head.Sign(key)
+	require.NoError(t, head.Verify(pub))
+
+	head.Size++
+	require.ErrorIs(t, head.Verify(pub), ErrInvalidProof)
+}
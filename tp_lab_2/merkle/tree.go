@@ -0,0 +1,121 @@
+package merkle
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Tree is an append-only Merkle tree. It keeps the hash of every complete
+// subtree so roots and proofs take O(log n) hashes.
+type Tree struct {
+	// levels[k][i] is the hash of leaves [i<<k, (i+1)<<k).
+	levels [][][]byte
+}
+
+// Append adds the leaf with hash leaf to the tree.
+func (t *Tree) Append(leaf []byte) {
+	h := leaf
+	for k := 0; ; k++ {
+		if k == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		t.levels[k] = append(t.levels[k], h)
+		n := len(t.levels[k])
+		if n%2 == 1 {
+			return
+		}
+		h = NodeHash(t.levels[k][n-2], h)
+	}
+}
+
+// Size returns the number of leaves in the tree.
+func (t *Tree) Size() uint64 {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return uint64(len(t.levels[0]))
+}
+
+// Leaf returns the hash of the index-th leaf.
+func (t *Tree) Leaf(index uint64) ([]byte, error) {
+	if index >= t.Size() {
+		return nil, fmt.Errorf("%w: leaf %d of tree size %d", ErrInvalidRange, index, t.Size())
+	}
+	return t.levels[0][index], nil
+}
+
+// Root returns the root hash of the tree made of the first size leaves.
+func (t *Tree) Root(size uint64) ([]byte, error) {
+	if size > t.Size() {
+		return nil, fmt.Errorf("%w: tree size %d, have %d", ErrInvalidRange, size, t.Size())
+	}
+	if size == 0 {
+		return EmptyRoot(), nil
+	}
+	return t.hash(0, size), nil
+}
+
+// InclusionProof returns the audit path of the index-th leaf in the tree
+// made of the first size leaves (RFC 6962, section 2.1.1).
+func (t *Tree) InclusionProof(index, size uint64) ([][]byte, error) {
+	if index >= size || size > t.Size() {
+		return nil, fmt.Errorf("%w: leaf %d of tree size %d, have %d",
+			ErrInvalidRange, index, size, t.Size())
+	}
+	return t.path(index, 0, size), nil
+}
+
+// ConsistencyProof proves the tree of size1 leaves is a prefix of the
+// tree of size2 leaves (RFC 6962, section 2.1.2).
+func (t *Tree) ConsistencyProof(size1, size2 uint64) ([][]byte, error) {
+	if size1 > size2 || size2 > t.Size() {
+		return nil, fmt.Errorf("%w: tree sizes %d and %d, have %d",
+			ErrInvalidRange, size1, size2, t.Size())
+	}
+	if size1 == 0 || size1 == size2 {
+		return nil, nil
+	}
+	return t.subproof(size1, 0, size2, true), nil
+}
+
+// hash returns the hash of leaves [lo, hi).
+func (t *Tree) hash(lo, hi uint64) []byte {
+	n := hi - lo
+	if k := bits.TrailingZeros64(n); n == 1<<k && lo%n == 0 {
+		return t.levels[k][lo>>k]
+	}
+	k := split(n)
+	return NodeHash(t.hash(lo, lo+k), t.hash(lo+k, hi))
+}
+
+func (t *Tree) path(m, lo, hi uint64) [][]byte {
+	n := hi - lo
+	if n == 1 {
+		return nil
+	}
+	k := split(n)
+	if m < k {
+		return append(t.path(m, lo, lo+k), t.hash(lo+k, hi))
+	}
+	return append(t.path(m-k, lo+k, hi), t.hash(lo, lo+k))
+}
+
+func (t *Tree) subproof(m, lo, hi uint64, complete bool) [][]byte {
+	n := hi - lo
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{t.hash(lo, hi)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(t.subproof(m, lo, lo+k, complete), t.hash(lo+k, hi))
+	}
+	return append(t.subproof(m-k, lo+k, hi, false), t.hash(lo, lo+k))
+}
+
+// split returns the largest power of two smaller than n, for n > 1.
+func split(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
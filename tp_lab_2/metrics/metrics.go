@@ -0,0 +1,404 @@
+// Package metrics keeps counters, gauges and histograms and exposes them
+// in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxSeries bounds the label value combinations of a vector. Values seen
+// once it is full are all counted in one series whose labels are Other,
+// so a misbehaving label can't grow memory or scrapes without bound.
+const (
+	MaxSeries = 200
+	Other     = "other"
+)
+
+// DefBuckets are histogram buckets for latencies in seconds.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and writes them out. The zero value is not
+// usable; create registries with NewRegistry. Registering a name again
+// returns the metric already registered, so a log reopened with the same
+// registry carries on counting, and GaugeFunc swaps in the new function.
+// Registering a name as another kind of metric panics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// metric writes every series of one metric family.
+type metric interface {
+	write(w *bufio.Writer, name string)
+	kind() string
+	help() string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// register adds m as name and returns it, or returns the metric already
+// registered as name.
+func (r *Registry) register(name string, m metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.metrics[name]; ok {
+		if reflect.TypeOf(old) != reflect.TypeOf(m) {
+			panic("metrics: " + name + " registered as another kind of metric")
+		}
+		return old
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by
+// name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for i, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", names[i], escapeHelp(m.help()))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", names[i], m.kind())
+		m.write(bw, names[i])
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// NewServer returns a server exposing the registry's metrics at /metrics,
+// meant to listen apart from the API.
+func NewServer(addr string, r *Registry) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Counter is a count that only goes up.
+type Counter struct {
+	helpText string
+	v        atomic.Uint64
+}
+
+// Counter registers a counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{helpText: help}
+	return r.register(name, c).(*Counter)
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+func (c *Counter) kind() string  { return "counter" }
+func (c *Counter) help() string  { return c.helpText }
+func (c *Counter) write(w *bufio.Writer, name string) {
+	writeSample(w, name, "", c.v.Load())
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	helpText string
+	bits     atomic.Uint64
+}
+
+// Gauge registers a gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{helpText: help}
+	return r.register(name, g).(*Gauge)
+}
+
+func (g *Gauge) Set(v float64)  { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Add(v float64)  { addFloat(&g.bits, v) }
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+func (g *Gauge) kind() string   { return "gauge" }
+func (g *Gauge) help() string   { return g.helpText }
+func (g *Gauge) write(w *bufio.Writer, name string) {
+	writeSample(w, name, "", g.Value())
+}
+
+// gaugeFunc is a gauge computed when the metrics are written.
+type gaugeFunc struct {
+	helpText string
+	fn       func() float64
+}
+
+// GaugeFunc registers a gauge whose value fn returns on every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	g := &gaugeFunc{helpText: help, fn: fn}
+	if r.register(name, g) != metric(g) {
+		r.mu.Lock()
+		r.metrics[name] = g
+		r.mu.Unlock()
+	}
+}
+
+func (g *gaugeFunc) kind() string { return "gauge" }
+func (g *gaugeFunc) help() string { return g.helpText }
+func (g *gaugeFunc) write(w *bufio.Writer, name string) {
+	writeSample(w, name, "", g.fn())
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	helpText string
+	upper    []float64
+	// counts[i] counts the observations in bucket i, the last one being
+	// +Inf; they are made cumulative when written.
+	counts []atomic.Uint64
+	sum    atomic.Uint64
+}
+
+// Histogram registers a histogram with the given bucket upper bounds,
+// which must be sorted.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(help, buckets)
+	return r.register(name, h).(*Histogram)
+}
+
+func newHistogram(help string, buckets []float64) *Histogram {
+	return &Histogram{
+		helpText: help,
+		upper:    buckets,
+		counts:   make([]atomic.Uint64, len(buckets)+1),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.counts[i].Add(1)
+	addFloat(&h.sum, v)
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) kind() string { return "histogram" }
+func (h *Histogram) help() string { return h.helpText }
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	h.writeLabeled(w, name, "")
+}
+
+func (h *Histogram) writeLabeled(w *bufio.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cum uint64
+	for i := range h.counts {
+		cum += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.upper) {
+			le = formatFloat(h.upper[i])
+		}
+		writeSample(w, name+"_bucket", labels+sep+`le="`+le+`"`, cum)
+	}
+	writeSample(w, name+"_sum", labels, math.Float64frombits(h.sum.Load()))
+	writeSample(w, name+"_count", labels, cum)
+}
+
+// vec holds the series of a metric with labels.
+type vec[T any] struct {
+	helpText string
+	labels   []string
+	newT     func() T
+	mu       sync.RWMutex
+	series   map[string]T
+	values   map[string][]string
+}
+
+func newVec[T any](help string, labels []string, newT func() T) *vec[T] {
+	return &vec[T]{
+		helpText: help,
+		labels:   labels,
+		newT:     newT,
+		series:   make(map[string]T),
+		values:   make(map[string][]string),
+	}
+}
+
+// with returns the series for values, which must be one per label.
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %d label values for %d labels", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	t, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return t
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t, ok = v.series[key]; ok {
+		return t
+	}
+	if len(v.series) >= MaxSeries {
+		values = make([]string, len(v.labels))
+		for i := range values {
+			values[i] = Other
+		}
+		key = strings.Join(values, "\xff")
+		if t, ok = v.series[key]; ok {
+			return t
+		}
+	}
+	t = v.newT()
+	v.series[key] = t
+	v.values[key] = append([]string(nil), values...)
+	return t
+}
+
+func (v *vec[T]) help() string { return v.helpText }
+
+// each calls fn with the labels of every series, sorted.
+func (v *vec[T]) each(fn func(labels string, t T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.mu.RLock()
+		t, values := v.series[key], v.values[key]
+		v.mu.RUnlock()
+		pairs := make([]string, len(values))
+		for i, value := range values {
+			pairs[i] = v.labels[i] + `="` + escapeLabel(value) + `"`
+		}
+		fn(strings.Join(pairs, ","), t)
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	v *vec[*Counter]
+}
+
+// CounterVec registers a counter with the given labels.
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(help, labels, func() *Counter { return &Counter{} })}
+	return r.register(name, c).(*CounterVec)
+}
+
+// With returns the counter for the label values, in label order.
+func (c *CounterVec) With(values ...string) *Counter { return c.v.with(values) }
+func (c *CounterVec) kind() string                   { return "counter" }
+func (c *CounterVec) help() string                   { return c.v.help() }
+func (c *CounterVec) write(w *bufio.Writer, name string) {
+	c.v.each(func(labels string, counter *Counter) {
+		writeSample(w, name, labels, counter.Value())
+	})
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	v *vec[*Histogram]
+}
+
+// HistogramVec registers a histogram with the given buckets and labels.
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{v: newVec(help, labels, func() *Histogram { return newHistogram("", buckets) })}
+	return r.register(name, h).(*HistogramVec)
+}
+
+// With returns the histogram for the label values, in label order.
+func (h *HistogramVec) With(values ...string) *Histogram { return h.v.with(values) }
+func (h *HistogramVec) kind() string                     { return "histogram" }
+func (h *HistogramVec) help() string                     { return h.v.help() }
+func (h *HistogramVec) write(w *bufio.Writer, name string) {
+	h.v.each(func(labels string, hist *Histogram) {
+		hist.writeLabeled(w, name, labels)
+	})
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func writeSample[V uint64 | float64](w *bufio.Writer, name, labels string, v V) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteByte(' ')
+	switch v := any(v).(type) {
+	case uint64:
+		w.WriteString(strconv.FormatUint(v, 10))
+	case float64:
+		w.WriteString(formatFloat(v))
+	}
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	appends := r.Counter("appends_total", "Records appended.")
+	appends.Add(3)
+	r.Gauge("segments", "Segments in the log.").Set(2)
+	r.GaugeFunc("up", "Always one.", func() float64 { return 1 })
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{.1, 1})
+	latency.Observe(.05)
+	latency.Observe(.5)
+	latency.Observe(5)
+	calls := r.CounterVec("calls_total", "Calls by method.", "method", "code")
+	calls.With("/log.v1.Log/Produce", "OK").Inc()
+	calls.With(`a"b`, "OK").Inc()
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	require.Equal(t, `# HELP appends_total Records appended.
+# TYPE appends_total counter
+appends_total 3
+# HELP calls_total Calls by method.
+# TYPE calls_total counter
+calls_total{method="/log.v1.Log/Produce",code="OK"} 1
+calls_total{method="a\"b",code="OK"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP segments Segments in the log.
+# TYPE segments gauge
+segments 2
+# HELP up Always one.
+# TYPE up gauge
+up 1
+`, b.String())
+
+	require.Panics(t, func() { r.Counter("up", "") })
+
+	// registering a name again carries on with its metric
+	require.Same(t, appends, r.Counter("appends_total", "Records appended."))
+	r.GaugeFunc("up", "Always one.", func() float64 { return 2 })
+	b.Reset()
+	_, err = r.WriteTo(&b)
+	require.NoError(t, err)
+	require.Contains(t, b.String(), "appends_total 3\n")
+	require.Contains(t, b.String(), "up 2\n")
+}
+
+func TestMaxSeries(t *testing.T) {
+	r := NewRegistry()
+	calls := r.CounterVec("calls_total", "", "user")
+	for i := 0; i < MaxSeries+50; i++ {
+		calls.With(fmt.Sprint(i)).Inc()
+	}
+	// past the limit, new values share one series
+	require.Equal(t, uint64(50), calls.With(Other).Value())
+	require.Equal(t, uint64(1), calls.With("0").Value())
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, MaxSeries+1, strings.Count(w.Body.String(), "calls_total{"))
+}
@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	api "example.com/tpmod/Api/v1"
+	log "example.com/tpmod/Log"
+)
+
+// Enforce returns an interceptor that rejects records without a schema ID
+// or whose value does not validate against their schema. When subjects
+// are given, the schema must also belong to one of them, so a log can be
+// tied to the subject of its topic.
+func (r *Registry) Enforce(subjects ...string) log.AppendInterceptor {
+	return func(ctx context.Context, record *api.Record, next log.AppendFunc) (uint64, error) {
+		if record.SchemaId == 0 {
+			return 0, api.ErrSchemaViolation{Reason: "record has no schema id"}
+		}
+		if len(subjects) > 0 {
+			s, err := r.Get(record.SchemaId)
+			if err != nil {
+				return 0, api.ErrSchemaViolation{
+					SchemaID: record.SchemaId,
+					Reason:   "unknown schema",
+				}
+			}
+			if !contains(subjects, s.Subject) {
+				return 0, api.ErrSchemaViolation{
+					SchemaID: record.SchemaId,
+					Reason: fmt.Sprintf("schema is for subject %s, want %s",
+						s.Subject, strings.Join(subjects, " or ")),
+				}
+			}
+		}
+		if err := r.Validate(record.SchemaId, record.Value); err != nil {
+			return 0, err
+		}
+		return next(ctx, record)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
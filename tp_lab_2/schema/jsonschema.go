@@ -0,0 +1,258 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the subset of JSON Schema the registry supports. Schemas
+// using other keywords are rejected rather than partially enforced.
+type jsonSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	ID          string `json:"$id,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type                 jsonTypes              `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+}
+
+// jsonTypes holds the "type" keyword, either one name or a list.
+type jsonTypes []string
+
+func (t *jsonTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = jsonTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+func (t jsonTypes) allows(name string) bool {
+	if len(t) == 0 {
+		return true
+	}
+	for _, v := range t {
+		if v == name || (v == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+var jsonTypeNames = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+func compileJSON(definition []byte) (*jsonSchema, error) {
+	dec := json.NewDecoder(bytes.NewReader(definition))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	s := &jsonSchema{}
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("decoding JSON schema: %w", err)
+	}
+	if err := s.check("$"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *jsonSchema) check(path string) error {
+	for _, t := range s.Type {
+		if !jsonTypeNames[t] {
+			return fmt.Errorf("%s: unknown type %q", path, t)
+		}
+	}
+	for name, p := range s.Properties {
+		if p == nil {
+			return fmt.Errorf("%s.%s: empty schema", path, name)
+		}
+		if err := p.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+func (s *jsonSchema) validate(value []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("not JSON: %v", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("not JSON: trailing data")
+	}
+	return s.validateValue(v, "$")
+}
+
+func (s *jsonSchema) validateValue(v interface{}, path string) error {
+	if t := jsonTypeOf(v); !s.Type.allows(t) {
+		return fmt.Errorf("%s: got %s, want %s", path, t, strings.Join(s.Type, " or "))
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, v) {
+		return fmt.Errorf("%s: value not in enum", path)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			p, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: property %q not allowed", path, name)
+				}
+				continue
+			}
+			if err := p.validateValue(v[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := s.Items.validateValue(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, e := range list {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isEmpty reports whether s accepts any value.
+func (s *jsonSchema) isEmpty() bool {
+	return len(s.Type) == 0 && len(s.Enum) == 0 && len(s.Properties) == 0 &&
+		len(s.Required) == 0 && s.AdditionalProperties == nil && s.Items == nil
+}
+
+func (s *jsonSchema) canRead(w validator) error {
+	writer, ok := w.(*jsonSchema)
+	if !ok {
+		return fmt.Errorf("schema types differ")
+	}
+	return s.accepts(writer, "$")
+}
+
+// accepts checks that every value valid under w is valid under s.
+func (s *jsonSchema) accepts(w *jsonSchema, path string) error {
+	if len(s.Type) > 0 {
+		if len(w.Type) == 0 {
+			return fmt.Errorf("%s: type restricted to %s", path, strings.Join(s.Type, " or "))
+		}
+		for _, t := range w.Type {
+			if !s.Type.allows(t) {
+				return fmt.Errorf("%s: type %s no longer allowed", path, t)
+			}
+		}
+	}
+	if len(s.Enum) > 0 {
+		if len(w.Enum) == 0 {
+			return fmt.Errorf("%s: values restricted to an enum", path)
+		}
+		for _, v := range w.Enum {
+			if !containsValue(s.Enum, v) {
+				return fmt.Errorf("%s: enum value %v removed", path, v)
+			}
+		}
+	}
+	for _, name := range s.Required {
+		if !contains(w.Required, name) {
+			return fmt.Errorf("%s: property %q became required", path, name)
+		}
+	}
+	wOpen := w.AdditionalProperties == nil || *w.AdditionalProperties
+	if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+		if wOpen {
+			return fmt.Errorf("%s: additional properties no longer allowed", path)
+		}
+		for name := range w.Properties {
+			if _, ok := s.Properties[name]; !ok {
+				return fmt.Errorf("%s: property %q removed", path, name)
+			}
+		}
+	}
+	for name, p := range s.Properties {
+		wp, ok := w.Properties[name]
+		if !ok {
+			if wOpen && !p.isEmpty() {
+				// values written with w may hold anything under name
+				return fmt.Errorf("%s: property %q added to an open object", path, name)
+			}
+			continue
+		}
+		if err := p.accepts(wp, path+"."+name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if w.Items == nil {
+			return fmt.Errorf("%s: array items restricted", path)
+		}
+		return s.Items.accepts(w.Items, path+"[]")
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package schema
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protoValidator validates values holding a marshaled protobuf message.
+type protoValidator struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func compileProto(definition []byte, message string) (*protoValidator, error) {
+	if message == "" {
+		return nil, fmt.Errorf("protobuf schemas need a message name")
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(definition, set); err != nil {
+		return nil, fmt.Errorf("decoding descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", message, err)
+	}
+	desc, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", message)
+	}
+	return &protoValidator{desc: desc}, nil
+}
+
+func (p *protoValidator) validate(value []byte) error {
+	m := dynamicpb.NewMessage(p.desc)
+	if err := proto.Unmarshal(value, m); err != nil {
+		return fmt.Errorf("not a %s: %v", p.desc.FullName(), err)
+	}
+	return checkUnknown(m)
+}
+
+// checkUnknown rejects messages with fields the schema does not declare,
+// which usually means the value was written with another schema.
+func checkUnknown(m protoreflect.Message) error {
+	if len(m.GetUnknown()) > 0 {
+		return fmt.Errorf("%s has fields not in the schema", m.Descriptor().FullName())
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				return true
+			}
+			v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				err = checkUnknown(v.Message())
+				return err == nil
+			})
+		case fd.Message() == nil:
+		case fd.IsList():
+			for i := 0; i < v.List().Len() && err == nil; i++ {
+				err = checkUnknown(v.List().Get(i).Message())
+			}
+		default:
+			err = checkUnknown(v.Message())
+		}
+		return err == nil
+	})
+	return err
+}
+
+func (p *protoValidator) canRead(w validator) error {
+	writer, ok := w.(*protoValidator)
+	if !ok {
+		return fmt.Errorf("schema types differ")
+	}
+	return readsMessage(p.desc, writer.desc, make(map[[2]protoreflect.FullName]bool))
+}
+
+// readsMessage checks that messages written as w decode as r: fields
+// sharing a number must keep their type and cardinality, and fields r
+// requires must be required by w too.
+func readsMessage(r, w protoreflect.MessageDescriptor, seen map[[2]protoreflect.FullName]bool) error {
+	key := [2]protoreflect.FullName{r.FullName(), w.FullName()}
+	if seen[key] {
+		return nil
+	}
+	seen[key] = true
+
+	fields := w.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		wf := fields.Get(i)
+		rf := r.Fields().ByNumber(wf.Number())
+		if rf == nil {
+			// skipped as an unknown field
+			continue
+		}
+		if rf.Kind() != wf.Kind() {
+			return fmt.Errorf("field %d of %s changes from %s to %s",
+				wf.Number(), r.FullName(), wf.Kind(), rf.Kind())
+		}
+		if rf.IsList() != wf.IsList() || rf.IsMap() != wf.IsMap() {
+			return fmt.Errorf("field %d of %s changes cardinality", wf.Number(), r.FullName())
+		}
+		switch {
+		case rf.IsMap():
+			if rf.MapValue().Kind() != wf.MapValue().Kind() {
+				return fmt.Errorf("field %d of %s changes its map values from %s to %s",
+					wf.Number(), r.FullName(), wf.MapValue().Kind(), rf.MapValue().Kind())
+			}
+			if m := rf.MapValue().Message(); m != nil {
+				if err := readsMessage(m, wf.MapValue().Message(), seen); err != nil {
+					return err
+				}
+			}
+		case rf.Message() != nil:
+			if err := readsMessage(rf.Message(), wf.Message(), seen); err != nil {
+				return err
+			}
+		}
+	}
+	fields = r.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		rf := fields.Get(i)
+		if rf.Cardinality() != protoreflect.Required {
+			continue
+		}
+		if wf := w.Fields().ByNumber(rf.Number()); wf == nil || wf.Cardinality() != protoreflect.Required {
+			return fmt.Errorf("field %d of %s is required but may be missing",
+				rf.Number(), r.FullName())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+// Package schema keeps a registry of versioned record schemas and checks
+// produced records against them.
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	api "example.com/tpmod/Api/v1"
+	log "example.com/tpmod/Log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Compatibility is the rule a new version of a subject's schema must
+// follow with respect to the previous version.
+type Compatibility int
+
+const (
+	// Backward lets readers using the new schema read data written with
+	// the previous one.
+	Backward Compatibility = iota
+	// Forward lets readers using the previous schema read data written
+	// with the new one.
+	Forward
+	// Full requires both Backward and Forward.
+	Full
+	// None accepts any new version.
+	None
+)
+
+func (c Compatibility) String() string {
+	switch c {
+	case Backward:
+		return "backward"
+	case Forward:
+		return "forward"
+	case Full:
+		return "full"
+	case None:
+		return "none"
+	}
+	return fmt.Sprintf("compatibility(%d)", int(c))
+}
+
+// Config configures a Registry.
+type Config struct {
+	// Compatibility applies to subjects missing from Subjects.
+	Compatibility Compatibility
+	Subjects      map[string]Compatibility
+}
+
+// Registry stores schemas in an internal log. The ID of a schema is its
+// offset in that log plus one, so records with no schema keep ID 0.
+type Registry struct {
+	mu       sync.RWMutex
+	config   Config
+	log      *log.Log
+	byID     map[uint64]*entry
+	subjects map[string][]*entry
+}
+
+type entry struct {
+	schema *api.Schema
+	v      validator
+}
+
+// NewRegistry opens the registry whose log is stored in dir.
+func NewRegistry(dir string, c Config) (*Registry, error) {
+	lc := log.Config{}
+	lc.Segment.MaxStoreBytes = 1 << 20
+	lc.Segment.MaxIndexBytes = 1 << 16
+	lc.Commit.Sync = true
+	l, err := log.NewLog(dir, lc)
+	if err != nil {
+		return nil, err
+	}
+	r := &Registry{
+		config:   c,
+		log:      l,
+		byID:     make(map[uint64]*entry),
+		subjects: make(map[string][]*entry),
+	}
+	if err = r.load(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Registry) load() error {
+	off, err := r.log.LowestOffset()
+	if err != nil {
+		return err
+	}
+	for ; ; off++ {
+		record, err := r.log.Read(off)
+		if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s := &api.Schema{}
+		if err = proto.Unmarshal(record.Value, s); err != nil {
+			return fmt.Errorf("schema at offset %d: %w", off, err)
+		}
+		s.Id = off + 1
+		v, err := compile(s)
+		if err != nil {
+			return fmt.Errorf("schema %d: %w", s.Id, err)
+		}
+		r.add(&entry{schema: s, v: v})
+	}
+}
+
+func (r *Registry) add(e *entry) {
+	r.byID[e.schema.Id] = e
+	r.subjects[e.schema.Subject] = append(r.subjects[e.schema.Subject], e)
+}
+
+// Register adds s as the next version of its subject and returns it with
+// its ID and version set. Registering the latest version again returns it
+// unchanged. The new version must be compatible with the previous one
+// under the subject's compatibility rule.
+func (r *Registry) Register(s *api.Schema) (*api.Schema, error) {
+	if s.Subject == "" {
+		return nil, status.Error(codes.InvalidArgument, "schema subject is required")
+	}
+	s = &api.Schema{
+		Subject:    s.Subject,
+		Type:       s.Type,
+		Definition: s.Definition,
+		Message:    s.Message,
+	}
+	v, err := compile(s)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid schema: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions := r.subjects[s.Subject]
+	if n := len(versions); n > 0 {
+		prev := versions[n-1]
+		if prev.schema.Type == s.Type && prev.schema.Message == s.Message &&
+			bytes.Equal(prev.schema.Definition, s.Definition) {
+			return prev.schema, nil
+		}
+		if err := r.checkCompatible(prev, &entry{schema: s, v: v}); err != nil {
+			return nil, err
+		}
+	}
+	s.Version = uint32(len(versions) + 1)
+	p, err := proto.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	off, err := r.log.Append(&api.Record{Value: p})
+	if err != nil {
+		return nil, err
+	}
+	s.Id = off + 1
+	r.add(&entry{schema: s, v: v})
+	return s, nil
+}
+
+func (r *Registry) checkCompatible(prev, next *entry) error {
+	c, ok := r.config.Subjects[next.schema.Subject]
+	if !ok {
+		c = r.config.Compatibility
+	}
+	if c == None {
+		return nil
+	}
+	if prev.schema.Type != next.schema.Type {
+		return status.Errorf(codes.FailedPrecondition,
+			"schema for %s changes type from %s to %s",
+			next.schema.Subject, prev.schema.Type, next.schema.Type)
+	}
+	var err error
+	if c == Backward || c == Full {
+		// the new schema reads what the previous one wrote
+		err = next.v.canRead(prev.v)
+	}
+	if err == nil && (c == Forward || c == Full) {
+		err = prev.v.canRead(next.v)
+	}
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition,
+			"schema for %s is not %s compatible with version %d: %v",
+			next.schema.Subject, c, prev.schema.Version, err)
+	}
+	return nil
+}
+
+// Get returns the schema with the given ID.
+func (r *Registry) Get(id uint64) (*api.Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.byID[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "schema %d not found", id)
+	}
+	return e.schema, nil
+}
+
+// Latest returns the latest version of the subject's schema.
+func (r *Registry) Latest(subject string) (*api.Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := r.subjects[subject]
+	if len(versions) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no schema for subject %s", subject)
+	}
+	return versions[len(versions)-1].schema, nil
+}
+
+// Validate checks value against the schema with the given ID.
+func (r *Registry) Validate(id uint64, value []byte) error {
+	r.mu.RLock()
+	e, ok := r.byID[id]
+	r.mu.RUnlock()
+	if !ok {
+		return api.ErrSchemaViolation{SchemaID: id, Reason: "unknown schema"}
+	}
+	if err := e.v.validate(value); err != nil {
+		return api.ErrSchemaViolation{SchemaID: id, Reason: err.Error()}
+	}
+	return nil
+}
+
+// Close closes the registry's log.
+func (r *Registry) Close() error {
+	return r.log.Close()
+}
@@ -0,0 +1,160 @@
+package schema
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestRegistry(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, r *Registry, dir string){
+		"json schemas evolve compatibly":     testJSONSchemas,
+		"protobuf schemas validate messages": testProtoSchemas,
+		"enforce rejects invalid records":    testEnforce,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "schema-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			r, err := NewRegistry(dir, Config{})
+			require.NoError(t, err)
+			defer r.Close()
+			fn(t, r, dir)
+		})
+	}
+}
+
+func jsonSchemaFor(subject, definition string) *api.Schema {
+	return &api.Schema{
+		Subject:    subject,
+		Type:       api.SchemaType_SCHEMA_TYPE_JSON,
+		Definition: []byte(definition),
+	}
+}
+
+func testJSONSchemas(t *testing.T, r *Registry, dir string) {
+	v1, err := r.Register(jsonSchemaFor("users", `{
+		"type": "object",
+		"properties": {"name": {"type": "string"}},
+		"required": ["name"],
+		"additionalProperties": false
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), v1.Id)
+	require.Equal(t, uint32(1), v1.Version)
+
+	again, err := r.Register(jsonSchemaFor("users", string(v1.Definition)))
+	require.NoError(t, err)
+	require.Equal(t, v1.Id, again.Id)
+
+	require.NoError(t, r.Validate(v1.Id, []byte(`{"name": "ana"}`)))
+	require.Error(t, r.Validate(v1.Id, []byte(`{"name": 3}`)))
+	require.Error(t, r.Validate(v1.Id, []byte(`{"name": "ana", "age": 3}`)))
+	require.Error(t, r.Validate(v1.Id, []byte(`not json`)))
+
+	// readers of v2 can read what v1 wrote
+	v2, err := r.Register(jsonSchemaFor("users", `{
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "age": {"type": "integer"}},
+		"required": ["name"],
+		"additionalProperties": false
+	}`))
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), v2.Version)
+	require.NoError(t, r.Validate(v2.Id, []byte(`{"name": "ana", "age": 3}`)))
+
+	// old values lack the age v3 requires
+	_, err = r.Register(jsonSchemaFor("users", `{
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "age": {"type": "integer"}},
+		"required": ["name", "age"],
+		"additionalProperties": false
+	}`))
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = r.Register(jsonSchemaFor("users", `{"type": "object", "pattern": "x"}`))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the registry is rebuilt from its log
+	require.NoError(t, r.Close())
+	r, err = NewRegistry(dir, Config{})
+	require.NoError(t, err)
+	defer r.Close()
+	latest, err := r.Latest("users")
+	require.NoError(t, err)
+	require.True(t, proto.Equal(v2, latest))
+	require.NoError(t, r.Validate(v1.Id, []byte(`{"name": "ana"}`)))
+}
+
+func testProtoSchemas(t *testing.T, r *Registry, _ string) {
+	file := protodesc.ToFileDescriptorProto(api.File_log_proto)
+	definition := func(file *descriptorpb.FileDescriptorProto) []byte {
+		b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+			File: []*descriptorpb.FileDescriptorProto{file},
+		})
+		require.NoError(t, err)
+		return b
+	}
+	v1, err := r.Register(&api.Schema{
+		Subject:    "records",
+		Type:       api.SchemaType_SCHEMA_TYPE_PROTOBUF,
+		Definition: definition(file),
+		Message:    "log.v1.Record",
+	})
+	require.NoError(t, err)
+
+	value, err := proto.Marshal(&api.Record{Value: []byte("hello"), Offset: 1})
+	require.NoError(t, err)
+	require.NoError(t, r.Validate(v1.Id, value))
+	value, err = proto.Marshal(&api.ProduceResponse{Offset: 1})
+	require.NoError(t, err)
+	require.Error(t, r.Validate(v1.Id, value), "field 1 of a Record is not a varint")
+	require.Error(t, r.Validate(v1.Id, []byte{0xff, 0xff}))
+
+	// changing the type of Record.value breaks old readers
+	changed := proto.Clone(file).(*descriptorpb.FileDescriptorProto)
+	for _, m := range changed.MessageType {
+		if m.GetName() == "Record" {
+			m.Field[0].Type = descriptorpb.FieldDescriptorProto_TYPE_UINT64.Enum()
+		}
+	}
+	_, err = r.Register(&api.Schema{
+		Subject:    "records",
+		Type:       api.SchemaType_SCHEMA_TYPE_PROTOBUF,
+		Definition: definition(changed),
+		Message:    "log.v1.Record",
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func testEnforce(t *testing.T, r *Registry, _ string) {
+	users, err := r.Register(jsonSchemaFor("users", `{"type": "object"}`))
+	require.NoError(t, err)
+	orders, err := r.Register(jsonSchemaFor("orders", `{"type": "array"}`))
+	require.NoError(t, err)
+
+	enforce := r.Enforce("users")
+	next := func(context.Context, *api.Record) (uint64, error) { return 7, nil }
+	for _, record := range []*api.Record{
+		{Value: []byte(`{}`)},
+		{Value: []byte(`[]`), SchemaId: orders.Id},
+		{Value: []byte(`[]`), SchemaId: users.Id},
+		{Value: []byte(`{}`), SchemaId: 99},
+	} {
+		_, err := enforce(context.Background(), record, next)
+		var violation api.ErrSchemaViolation
+		require.ErrorAs(t, err, &violation)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+	off, err := enforce(context.Background(), &api.Record{Value: []byte(`{}`), SchemaId: users.Id}, next)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), off)
+}
@@ -0,0 +1,26 @@
+package schema
+
+import (
+	"fmt"
+
+	api "example.com/tpmod/Api/v1"
+)
+
+// validator is a compiled schema.
+type validator interface {
+	// validate checks that value is written with the schema.
+	validate(value []byte) error
+	// canRead checks that values written with w can be read with the
+	// schema, which is what compatibility between versions is built on.
+	canRead(w validator) error
+}
+
+func compile(s *api.Schema) (validator, error) {
+	switch s.Type {
+	case api.SchemaType_SCHEMA_TYPE_PROTOBUF:
+		return compileProto(s.Definition, s.Message)
+	case api.SchemaType_SCHEMA_TYPE_JSON:
+		return compileJSON(s.Definition)
+	}
+	return nil, fmt.Errorf("unsupported schema type %s", s.Type)
+}
@@ -1,21 +1,48 @@
 package Server
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	api "example.com/tpmod/Api/v1"
 	tlsconfig "example.com/tpmod/CA"
 	log "example.com/tpmod/Log"
 	"example.com/tpmod/auth"
+	"example.com/tpmod/hlc"
+	"example.com/tpmod/merkle"
+	"example.com/tpmod/metrics"
+	"example.com/tpmod/schema"
+	"example.com/tpmod/shred"
+	"example.com/tpmod/tracing"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestServer(t *testing.T) {
@@ -29,6 +56,12 @@ func TestServer(t *testing.T) {
 		"produce/consume stream succeeds":                     testProduceConsumeStream,
 		"consume past log boundary fails":                     testConsumePastBoundary,
 		"test all endpoints from an unauthorized user":        testUnauthorized,
+		"upload a record larger than a message succeeds":      testProduceUpload,
+		"produced records are stamped by interceptors":        testStampHeaders,
+		"erased records are consumed as erased":               testErase,
+		"consume batches with limits, long polls and credits": testConsumeBatches,
+		"pipelined produce stream acks in order":              testProduceStreamPipelined,
+		"log info reports the offset range":                   testLogInfo,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			rootClient, nobodyClient, config, teardown := setupTest(t, nil)
@@ -48,15 +81,23 @@ func setupTest(t *testing.T, fn func(*Config)) (
 	teardown func(),
 ) {
 	t.Helper()
+	rootConn, nobodyConn, config, teardown := setupConns(t, fn)
+	return api.NewLogClient(rootConn), api.NewLogClient(nobodyConn), config, teardown
+}
+
+// setupConns sets up like setupTest but returns the connections of the
+// clients, for tests calling other services than Log.
+func setupConns(t *testing.T, fn func(*Config)) (
+	rootConn, nobodyConn *grpc.ClientConn,
+	config *Config,
+	teardown func(),
+) {
+	t.Helper()
 
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
 
-	newClient := func(crtPath, keyPath string) (
-		*grpc.ClientConn,
-		api.LogClient,
-		[]grpc.DialOption,
-	) {
+	newConn := func(crtPath, keyPath string) *grpc.ClientConn {
 		tlsConfig, err := tlsconfig.SetupTLSConfig(tlsconfig.TLSConfig{
 			CertFile: crtPath,
 			KeyFile:  keyPath,
@@ -68,18 +109,15 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		opts := []grpc.DialOption{grpc.WithTransportCredentials(tlscreds)}
 		conn, err := grpc.NewClient(l.Addr().String(), opts...)
 		require.NoError(t, err)
-		client := api.NewLogClient(conn)
-		return conn, client, opts
+		return conn
 	}
 
-	var rootConn *grpc.ClientConn
-	rootConn, rootClient, _ = newClient(
+	rootConn = newConn(
 		tlsconfig.RootClientCertFile,
 		tlsconfig.RootClientKeyFile,
 	)
 
-	var nobodyConn *grpc.ClientConn
-	nobodyConn, nobodyClient, _ = newClient(
+	nobodyConn = newConn(
 		tlsconfig.NobodyClientCertFile,
 		tlsconfig.NobodyClientKeyFile,
 	)
@@ -116,7 +154,7 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		server.Serve(l)
 	}()
 
-	return rootClient, nobodyClient, config, func() {
+	return rootConn, nobodyConn, config, func() {
 		server.Stop()
 		rootConn.Close()
 		nobodyConn.Close()
@@ -124,6 +162,34 @@ func setupTest(t *testing.T, fn func(*Config)) (
 	}
 }
 
+// withLog replaces the log of the setup with one created with c.
+func withLog(t *testing.T, c log.Config) func(*Config) {
+	return func(config *Config) {
+		require.NoError(t, config.CommitLog.(*log.Log).Remove())
+		dir, err := os.MkdirTemp("", "server-test")
+		require.NoError(t, err)
+		clog, err := log.NewLog(dir, c)
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Remove() })
+		config.CommitLog = clog
+	}
+}
+
+// withSchemas serves a schema registry.
+func withSchemas(t *testing.T) func(*Config) {
+	return func(config *Config) {
+		dir, err := os.MkdirTemp("", "server-test-schemas")
+		require.NoError(t, err)
+		schemas, err := schema.NewRegistry(dir, schema.Config{})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			schemas.Close()
+			os.RemoveAll(dir)
+		})
+		config.Schemas = schemas
+	}
+}
+
 // END: setup
 
 // START: produceconsume
@@ -262,3 +328,1076 @@ func testUnauthorized(
 		t.Fatalf("got code: %d, want: %d", gotCode, wantCode)
 	}
 }
+
+func testProduceUpload(
+	t *testing.T, client, _ api.LogClient, config *Config,
+) {
+	ctx := context.Background()
+
+	// larger than both gRPC's default message limit and a segment
+	want := bytes.Repeat([]byte("0123456789abcdef"), 6<<16)
+	stream, err := client.ProduceUpload(ctx)
+	require.NoError(t, err)
+	const chunk = 1 << 20
+	for i := 0; i < len(want); i += chunk {
+		req := &api.UploadRequest{Chunk: want[i:min(i+chunk, len(want))]}
+		if i == 0 {
+			req.Record = &api.Record{}
+		}
+		require.NoError(t, stream.Send(req))
+	}
+	produce, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	consume, err := client.Consume(
+		ctx,
+		&api.ConsumeRequest{Offset: produce.Offset},
+		grpc.MaxCallRecvMsgSize(len(want)+1024),
+	)
+	require.NoError(t, err)
+	require.Equal(t, want, consume.Record.Value)
+}
+
+func TestProduceUploadLimit(t *testing.T) {
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.MaxUploadBytes = 1024
+	})
+	defer teardown()
+
+	stream, err := client.ProduceUpload(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		req := &api.UploadRequest{Chunk: bytes.Repeat([]byte("x"), 512)}
+		if i == 0 {
+			req.Record = &api.Record{}
+		}
+		// the server may have failed the upload already
+		if err = stream.Send(req); err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func testStampHeaders(
+	t *testing.T, client, _ api.LogClient, config *Config,
+) {
+	clog := config.CommitLog.(*log.Log)
+	clog.Use(StampProducer, StampTraceID)
+	ctx := metadata.AppendToOutgoingContext(
+		context.Background(), traceIDMetadata, "trace-1",
+	)
+
+	// the producer header is the authenticated subject, whatever the
+	// client sent
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{
+			Value:   []byte("hello world"),
+			Headers: map[string]string{ProducerHeader: "mallory"},
+		},
+	})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset: produce.Offset,
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		ProducerHeader: "root",
+		TraceIDHeader:  "trace-1",
+	}, consume.Record.Headers)
+
+	// and is dropped when there's no subject to stamp
+	off, err := clog.AppendContext(context.Background(), &api.Record{
+		Value:   []byte("hello world"),
+		Headers: map[string]string{ProducerHeader: "mallory"},
+	})
+	require.NoError(t, err)
+	record, err := clog.Read(off)
+	require.NoError(t, err)
+	require.NotContains(t, record.Headers, ProducerHeader)
+}
+
+func TestSchemaEnforced(t *testing.T) {
+	client, _, config, teardown := setupTest(t, withSchemas(t))
+	defer teardown()
+	ctx := context.Background()
+	user, err := config.Schemas.Register(&api.Schema{
+		Subject:    "users",
+		Type:       api.SchemaType_SCHEMA_TYPE_JSON,
+		Definition: []byte(`{"type": "object", "required": ["name"]}`),
+	})
+	require.NoError(t, err)
+	config.CommitLog.(*log.Log).Use(config.Schemas.Enforce("users"))
+
+	for _, record := range []*api.Record{
+		{Value: []byte(`{"name": "ana"}`)},
+		{Value: []byte(`{"age": 3}`), SchemaId: user.Id},
+		{Value: []byte(`{"name": "ana"}`), SchemaId: user.Id + 1},
+	} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte(`{"name": "ana"}`), SchemaId: user.Id},
+	})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, user.Id, consume.Record.SchemaId)
+}
+
+func TestTreeProofs(t *testing.T) {
+	_, treeKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	c := log.Config{}
+	c.Merkle.Enabled = true
+	c.Merkle.SigningKey = treeKey
+	client, _, config, teardown := setupTest(t, withLog(t, c))
+	defer teardown()
+	ctx := context.Background()
+	clog := config.CommitLog.(*log.Log)
+	pub := clog.Config.Merkle.SigningKey.Public().(ed25519.PublicKey)
+
+	headAt := func() *merkle.TreeHead {
+		res, err := client.GetTreeHead(ctx, &api.GetTreeHeadRequest{})
+		require.NoError(t, err)
+		head := &merkle.TreeHead{
+			Size:      res.TreeHead.TreeSize,
+			Timestamp: res.TreeHead.Timestamp,
+			Root:      res.TreeHead.RootHash,
+			Signature: res.TreeHead.Signature,
+		}
+		require.NoError(t, head.Verify(pub))
+		return head
+	}
+	produce := func(value string) uint64 {
+		res, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(value)},
+		})
+		require.NoError(t, err)
+		return res.Offset
+	}
+
+	produce("first")
+	first := headAt()
+	off := produce("second")
+	produce("third")
+	second := headAt()
+	require.Equal(t, uint64(3), second.Size)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: off})
+	require.NoError(t, err)
+	leaf, err := merkle.RecordLeaf(consume.Record)
+	require.NoError(t, err)
+	inclusion, err := client.GetInclusionProof(ctx, &api.GetInclusionProofRequest{Offset: off})
+	require.NoError(t, err)
+	require.Equal(t, second.Size, inclusion.TreeSize)
+	require.NoError(t, merkle.VerifyInclusion(
+		leaf, inclusion.LeafIndex, inclusion.TreeSize, inclusion.AuditPath, second.Root,
+	))
+
+	consistency, err := client.GetConsistencyProof(ctx, &api.GetConsistencyProofRequest{
+		First: first.Size, Second: second.Size,
+	})
+	require.NoError(t, err)
+	require.NoError(t, merkle.VerifyConsistency(
+		first.Size, second.Size, consistency.Proof, first.Root, second.Root,
+	))
+
+	_, err = client.GetInclusionProof(ctx, &api.GetInclusionProofRequest{Offset: 10})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func testErase(
+	t *testing.T, client, nobodyClient api.LogClient, config *Config,
+) {
+	ctx := context.Background()
+	dir, err := os.MkdirTemp("", "server-test-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keys, err := shred.NewFileKeyStore(dir)
+	require.NoError(t, err)
+	shredder := shred.New(keys)
+	clog := config.CommitLog.(*log.Log)
+	clog.Use(shredder.Encrypt)
+	clog.UseRead(shredder.Decrypt)
+	config.Eraser = shredder
+
+	var offsets []uint64
+	for _, record := range []*api.Record{
+		{Value: []byte("ana's data"), DataSubject: "ana"},
+		{Value: []byte("bob's data"), DataSubject: "bob"},
+	} {
+		res, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+		require.NoError(t, err)
+		offsets = append(offsets, res.Offset)
+	}
+	stored, err := clog.Read(offsets[0])
+	require.NoError(t, err)
+	require.Equal(t, []byte("ana's data"), stored.Value)
+
+	_, err = nobodyClient.Erase(ctx, &api.EraseRequest{DataSubject: "ana"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Erase(ctx, &api.EraseRequest{DataSubject: "ana"})
+	require.NoError(t, err)
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: offsets[0]})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.True(t, res.Record.Erased)
+	require.Empty(t, res.Record.Value)
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.False(t, res.Record.Erased)
+	require.Equal(t, []byte("bob's data"), res.Record.Value)
+}
+
+func TestConsumeTruncated(t *testing.T) {
+	c := log.Config{}
+	c.Segment.MaxStoreBytes = 32
+	client, _, config, teardown := setupTest(t, withLog(t, c))
+	defer teardown()
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(context.Background(), &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, config.CommitLog.(*log.Log).Truncate(1))
+
+	// streams from a truncated offset fail rather than wait for it
+	want := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
+	for _, req := range []*api.ConsumeRequest{
+		{Offset: 0, MaxWaitMs: 10},
+		{Offset: 0, MaxWaitMs: 10, MaxRecords: 2},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		stream, err := client.ConsumeStream(ctx, req)
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, want, status.Code(err))
+	}
+}
+
+func TestHLC(t *testing.T) {
+	c := log.Config{}
+	c.Clock = hlc.NewClock(nil, time.Minute)
+	client, _, _, teardown := setupTest(t, withLog(t, c))
+	defer teardown()
+	ctx := context.Background()
+	first, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("first")},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, first.Hlc)
+
+	// another service carries the timestamp it got into its next record
+	cause := &api.HLC{WallTime: first.Hlc.WallTime + int64(time.Second), Logical: 7}
+	second, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("second"), Hlc: cause},
+	})
+	require.NoError(t, err)
+	require.Equal(t, cause.WallTime, second.Hlc.WallTime)
+	require.Equal(t, cause.Logical+1, second.Hlc.Logical)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: second.Offset})
+	require.NoError(t, err)
+	require.Equal(t, second.Hlc.WallTime, consume.Record.Hlc.WallTime)
+	require.Equal(t, second.Hlc.Logical, consume.Record.Hlc.Logical)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{
+			Value: []byte("from the future"),
+			Hlc:   &api.HLC{WallTime: time.Now().Add(time.Hour).UnixNano()},
+		},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// logs without a clock neither keep nor echo producers' timestamps
+	client, _, _, teardown = setupTest(t, nil)
+	defer teardown()
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("unstamped"), Hlc: cause},
+	})
+	require.NoError(t, err)
+	require.Nil(t, produce.Hlc)
+	consume, err = client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Nil(t, consume.Record.Hlc)
+}
+
+func testConsumeBatches(
+	t *testing.T, client, _ api.LogClient, config *Config,
+) {
+	ctx := context.Background()
+	produce := func(value string) {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(value)},
+		})
+		require.NoError(t, err)
+	}
+	values := func(res *api.ConsumeResponse) []string {
+		var got []string
+		for _, record := range res.Records {
+			got = append(got, string(record.Value))
+		}
+		return got
+	}
+	for _, v := range []string{"a", "b", "c", "d", "e"} {
+		produce(v)
+	}
+
+	// a bounded stream in batches of two ends at its end offset
+	end := uint64(5)
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		MaxRecords: 2,
+		EndOffset:  &end,
+	})
+	require.NoError(t, err)
+	var batches [][]string
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		batches = append(batches, values(res))
+	}
+	require.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, batches)
+
+	// a batch holds a single record past max bytes
+	res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 1, MaxBytes: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, values(res))
+
+	// a long poll returns once the offset is appended
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		produce("f")
+	}()
+	res, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 5, MaxWaitMs: 5000})
+	require.NoError(t, err)
+	require.Equal(t, []byte("f"), res.Record.Value)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 6, MaxWaitMs: 10})
+	require.Error(t, err)
+
+	// a flow sends only as many batches as it has credits for
+	flow, err := client.ConsumeFlow(ctx)
+	require.NoError(t, err)
+	require.NoError(t, flow.Send(&api.ConsumeFlowRequest{
+		Request: &api.ConsumeRequest{MaxRecords: 4, MaxWaitMs: 10},
+		Credits: 1,
+	}))
+	res, err = flow.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d"}, values(res))
+	require.NoError(t, flow.Send(&api.ConsumeFlowRequest{Credits: 2}))
+	res, err = flow.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []string{"e", "f"}, values(res))
+	// nothing was appended within the max wait
+	res, err = flow.Recv()
+	require.NoError(t, err)
+	require.Empty(t, res.Records)
+	require.NoError(t, flow.CloseSend())
+	_, err = flow.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func testProduceStreamPipelined(
+	t *testing.T, client, _ api.LogClient, config *Config,
+) {
+	config.MaxProduceInFlight = 4
+	ctx := context.Background()
+	stream, err := client.ProduceStream(ctx)
+	require.NoError(t, err)
+
+	// send everything before reading any ack; the server reads ahead of
+	// its acks up to the in-flight limit
+	const n = 50
+	for i := 0; i < n; i++ {
+		err = stream.Send(&api.ProduceRequest{
+			Record:        &api.Record{Value: []byte(fmt.Sprintf("record %d", i))},
+			CorrelationId: uint64(1000 + i),
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CloseSend())
+	for i := 0; i < n; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(1000+i), res.CorrelationId)
+		require.Equal(t, uint64(i), res.Offset)
+	}
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: n - 1})
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("record %d", n-1), string(res.Record.Value))
+}
+
+func testLogInfo(
+	t *testing.T, client, nobody api.LogClient, config *Config,
+) {
+	ctx := context.Background()
+	info, err := client.GetLogInfo(ctx, &api.GetLogInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, info.LowestOffset, info.NextOffset)
+	require.Equal(t, uint32(1), info.Segments)
+	require.NotEmpty(t, info.Version)
+
+	for i := 0; i < 3; i++ {
+		_, err = client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("hello world")},
+		})
+		require.NoError(t, err)
+	}
+	info, err = client.GetLogInfo(ctx, &api.GetLogInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), info.LowestOffset)
+	require.Equal(t, uint64(2), info.HighestOffset)
+	require.Equal(t, uint64(3), info.NextOffset)
+	require.Greater(t, info.BytesOnDisk, uint64(0))
+
+	_, err = nobody.GetLogInfo(ctx, &api.GetLogInfoRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestHealth(t *testing.T) {
+	conn, _, config, teardown := setupConns(t, withSchemas(t))
+	defer teardown()
+	ctx := context.Background()
+	client := api.NewLogClient(conn)
+	health := healthpb.NewHealthClient(conn)
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return res.Status
+	}
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("log.v1.Log"))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("log.v1.Schemas"))
+	_, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: "nope"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	watch, err := health.Watch(ctx, &healthpb.HealthCheckRequest{Service: "log.v1.Log"})
+	require.NoError(t, err)
+	res, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	// a log out of disk space stops serving
+	clog := config.CommitLog.(*log.Log)
+	clog.Config.Disk.LowWatermark = math.MaxUint64
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("x")}})
+	require.Error(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	res, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+}
+
+func TestAdmin(t *testing.T) {
+	rootConn, nobodyConn, config, teardown := setupConns(t, nil)
+	defer teardown()
+	client := api.NewLogClient(rootConn)
+	ctx := context.Background()
+	admin := api.NewAdminClient(rootConn)
+	clog := config.CommitLog.(*log.Log)
+
+	_, err := api.NewAdminClient(nobodyConn).ListSegments(ctx, &api.ListSegmentsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	produce := func(n int) {
+		for i := 0; i < n; i++ {
+			_, err := client.Produce(ctx, &api.ProduceRequest{
+				Record: &api.Record{Value: []byte("hello world")},
+			})
+			require.NoError(t, err)
+		}
+	}
+	produce(2)
+	rolled, err := admin.RollSegment(ctx, &api.RollSegmentRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), rolled.Segment.BaseOffset)
+	require.False(t, rolled.Segment.Sealed)
+	produce(2)
+	_, err = admin.RollSegment(ctx, &api.RollSegmentRequest{})
+	require.NoError(t, err)
+	produce(1)
+
+	list, err := admin.ListSegments(ctx, &api.ListSegmentsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Segments, 3)
+	require.Equal(t, uint64(0), list.Segments[0].BaseOffset)
+	require.Equal(t, uint64(2), list.Segments[0].NextOffset)
+	require.True(t, list.Segments[0].Sealed)
+	require.Greater(t, list.Segments[0].StoreBytes, uint64(0))
+
+	// destructive calls only say what they would do until confirmed
+	_, err = admin.Truncate(ctx, &api.TruncateRequest{Lowest: 1})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "[0, 2)")
+	lowest, err := clog.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+
+	truncated, err := admin.Truncate(ctx, &api.TruncateRequest{Lowest: 1, Confirm: true})
+	require.NoError(t, err)
+	require.Len(t, truncated.Removed, 1)
+	lowest, err = clog.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+
+	_, err = admin.ApplyRetention(ctx, &api.ApplyRetentionRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = admin.ApplyRetention(ctx, &api.ApplyRetentionRequest{MaxBytes: 1})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	retained, err := admin.ApplyRetention(ctx, &api.ApplyRetentionRequest{MaxBytes: 1, Confirm: true})
+	require.NoError(t, err)
+	require.Len(t, retained.Removed, 1)
+	require.Equal(t, uint64(2), retained.Removed[0].BaseOffset)
+
+	usage, err := admin.GetDiskUsage(ctx, &api.GetDiskUsageRequest{})
+	require.NoError(t, err)
+	require.Len(t, usage.Dirs, 1)
+	require.Equal(t, usage.Dirs[0].Bytes, usage.TotalBytes)
+	require.Greater(t, usage.TotalBytes, uint64(0))
+
+	// the active segment survives any truncation
+	_, err = admin.Truncate(ctx, &api.TruncateRequest{Lowest: 100, Confirm: true})
+	require.NoError(t, err)
+	produce(1)
+}
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	client, nobody, _, teardown := setupTest(t, func(c *Config) {
+		c.Metrics = registry
+	})
+	defer teardown()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := metrics.NewServer("", registry)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	ctx := context.Background()
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	_, err = nobody.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	res, err := http.Get("http://" + l.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	for _, line := range []string{
+		`grpc_server_handled_total{method="/log.v1.Log/Produce",code="OK"} 1`,
+		`grpc_server_handled_total{method="/log.v1.Log/Produce",code="PermissionDenied"} 1`,
+		`grpc_server_denied_total{method="/log.v1.Log/Produce",code="PermissionDenied"} 1`,
+		`grpc_server_handling_seconds_count{method="/log.v1.Log/Produce"} 2`,
+		`grpc_server_in_flight 0`,
+	} {
+		require.Contains(t, string(b), line+"\n")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	spans := &tracing.Recorder{}
+	client, _, config, teardown := setupTest(t, func(c *Config) {
+		c.Tracer = tracing.NewTracer(spans)
+	})
+	defer teardown()
+	config.CommitLog.(*log.Log).Use(StampTraceID)
+
+	// ended returns the spans of the trace, by name, once the RPC's span
+	// ended, which happens after the client got its response.
+	ended := func(traceID tracing.TraceID, method string) map[string]tracing.SpanData {
+		byName := make(map[string]tracing.SpanData)
+		require.Eventually(t, func() bool {
+			for _, span := range spans.Spans() {
+				if span.TraceID == traceID.String() {
+					byName[span.Name] = span
+				}
+			}
+			_, ok := byName[method]
+			return ok
+		}, time.Second, 10*time.Millisecond)
+		return byName
+	}
+
+	upstream, err := tracing.Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), tracing.Header, upstream.String())
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	byName := ended(upstream.TraceID, "/log.v1.Log/Produce")
+	rpc := byName["/log.v1.Log/Produce"]
+	require.Equal(t, upstream.SpanID.String(), rpc.ParentID)
+	for _, name := range []string{"authenticate", "authorize", "log.Append"} {
+		require.Equal(t, rpc.SpanID, byName[name].ParentID, name)
+	}
+	require.Equal(t, "root", byName["authenticate"].Attributes["subject"])
+	require.Equal(t, produceAction, byName["authorize"].Attributes["action"])
+	require.Equal(t, fmt.Sprint(produce.Offset), byName["log.Append"].Attributes["offset"])
+
+	// the record carries the trace on to its consumers
+	consume, err := client.Consume(context.Background(), &api.ConsumeRequest{
+		Offset: produce.Offset,
+	})
+	require.NoError(t, err)
+	require.Equal(t, upstream.TraceID.String(), consume.Record.Headers[TraceIDHeader])
+	appended, err := tracing.Parse(consume.Record.Headers[TraceParentHeader])
+	require.NoError(t, err)
+	require.Equal(t, upstream.TraceID, appended.TraceID)
+	require.Equal(t, byName["log.Append"].SpanID, appended.SpanID.String())
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), tracing.Header, appended.String())
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	byName = ended(upstream.TraceID, "/log.v1.Log/Consume")
+	rpc = byName["/log.v1.Log/Consume"]
+	require.Equal(t, appended.SpanID.String(), rpc.ParentID)
+	require.Equal(t, rpc.SpanID, byName["log.Read"].ParentID)
+	require.Empty(t, byName["log.Read"].Error)
+}
+
+func TestHTTPTracing(t *testing.T) {
+	spans := &tracing.Recorder{}
+	srv := &HTTPServer{
+		Authorizer: auth.New(tlsconfig.ACLModelFile, tlsconfig.ACLPolicyFile),
+		Tracer:     tracing.NewTracer(spans),
+	}
+	serve := func(subject string, next http.HandlerFunc) (*httptest.ResponseRecorder, tracing.SpanData) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/consume", nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+			{Subject: pkix.Name{CommonName: subject}},
+		}}}
+		w := httptest.NewRecorder()
+		before := len(spans.Spans())
+		srv.authorized(consumeAction, next)(w, r)
+		var ended []tracing.SpanData
+		for _, span := range spans.Spans()[before:] {
+			if span.Name == "GET /consume" {
+				ended = append(ended, span)
+			}
+		}
+		// the request's span ends exactly once
+		require.Len(t, ended, 1)
+		return w, ended[0]
+	}
+
+	w, span := serve("root", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+		w.(http.Flusher).Flush()
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, w.Flushed)
+	require.Empty(t, span.Error)
+
+	// handlers failing after authorization fail the span too
+	w, span = serve("root", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "offset out of range", http.StatusNotFound)
+	})
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "404 Not Found", span.Error)
+
+	w, span = serve("nobody", func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unauthorized request served")
+	})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.NotEmpty(t, span.Error)
+}
+
+// setupHTTPTest serves a log over HTTPS and returns clients with the root
+// and nobody certificates.
+func setupHTTPTest(t *testing.T) (
+	clog *log.Log,
+	srv *http.Server,
+	addr string,
+	root, nobody *http.Client,
+	teardown func(),
+) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "http-server-test")
+	require.NoError(t, err)
+	clog, err = log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	serverTLSConfig, err := tlsconfig.SetupTLSConfig(tlsconfig.TLSConfig{
+		CertFile: tlsconfig.ServerCertFile,
+		KeyFile:  tlsconfig.ServerKeyFile,
+		CAFile:   tlsconfig.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	authorizer := auth.New(tlsconfig.ACLModelFile, tlsconfig.ACLPolicyFile)
+	srv = NewHTTPServer("", clog, authorizer, serverTLSConfig, nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.ServeTLS(l, "", "")
+
+	newClient := func(crtPath, keyPath string) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: clientTLSConfig(t, crtPath, keyPath),
+		}}
+	}
+	root = newClient(tlsconfig.RootClientCertFile, tlsconfig.RootClientKeyFile)
+	nobody = newClient(tlsconfig.NobodyClientCertFile, tlsconfig.NobodyClientKeyFile)
+	return clog, srv, l.Addr().String(), root, nobody, func() {
+		srv.Close()
+		clog.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func clientTLSConfig(t *testing.T, crtPath, keyPath string) *tls.Config {
+	tlsConfig, err := tlsconfig.SetupTLSConfig(tlsconfig.TLSConfig{
+		CertFile: crtPath,
+		KeyFile:  keyPath,
+		CAFile:   tlsconfig.CAFile,
+	})
+	require.NoError(t, err)
+	return tlsConfig
+}
+
+func TestHTTPServer(t *testing.T) {
+	clog, srv, addr, root, nobody, teardown := setupHTTPTest(t)
+	defer teardown()
+
+	url := "https://" + addr
+	produce := func(client *http.Client) int {
+		res, err := client.Post(url+"/produce", "application/json",
+			bytes.NewBufferString(`{"record":{"value":"aGVsbG8="}}`))
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	consume := func(client *http.Client) int {
+		res, err := client.Get(url + "/consume?offset=0")
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	require.Equal(t, http.StatusCreated, produce(root))
+	require.Equal(t, http.StatusOK, consume(root))
+	require.Equal(t, http.StatusForbidden, produce(nobody))
+	require.Equal(t, http.StatusForbidden, consume(nobody))
+	off, err := clog.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	// over plain HTTP there is no certificate to take a subject from
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/consume?offset=0", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHTTPTail(t *testing.T) {
+	clog, _, addr, root, nobody, teardown := setupHTTPTest(t)
+	defer teardown()
+
+	appendValues := func(values ...string) {
+		for _, v := range values {
+			_, err := clog.Append(&api.Record{Value: []byte(v)})
+			require.NoError(t, err)
+		}
+	}
+	appendValues("a", "b")
+
+	t.Run("server-sent events", func(t *testing.T) {
+		tail := func(client *http.Client, lastEventID string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, "https://"+addr+"/tail?offset=0", nil)
+			require.NoError(t, err)
+			if lastEventID != "" {
+				req.Header.Set("Last-Event-ID", lastEventID)
+			}
+			res, err := client.Do(req)
+			require.NoError(t, err)
+			return res
+		}
+		res := tail(nobody, "")
+		res.Body.Close()
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		res = tail(root, "")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+		events := bufio.NewReader(res.Body)
+		next := func() (id string, record *api.Record) {
+			record = &api.Record{}
+			for {
+				line, err := events.ReadString('\n')
+				require.NoError(t, err)
+				line = strings.TrimSuffix(line, "\n")
+				switch {
+				case line == "":
+					return id, record
+				case strings.HasPrefix(line, "id: "):
+					id = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "data: "):
+					require.NoError(t, protojson.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), record))
+				}
+			}
+		}
+		for i, want := range []string{"a", "b"} {
+			id, record := next()
+			require.Equal(t, fmt.Sprint(i), id)
+			require.Equal(t, want, string(record.Value))
+		}
+		// records appended after the tail started are streamed as well
+		appendValues("c")
+		id, record := next()
+		require.Equal(t, "2", id)
+		require.Equal(t, "c", string(record.Value))
+
+		// a reconnecting client resumes after its last event
+		res = tail(root, "1")
+		defer res.Body.Close()
+		events = bufio.NewReader(res.Body)
+		id, record = next()
+		require.Equal(t, "2", id)
+		require.Equal(t, "c", string(record.Value))
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		dial := func(crtPath, keyPath, origin string) (*websocket.Conn, error) {
+			config, err := websocket.NewConfig("wss://"+addr+"/tail/ws?offset=0&window=2", origin)
+			require.NoError(t, err)
+			config.TlsConfig = clientTLSConfig(t, crtPath, keyPath)
+			return websocket.DialConfig(config)
+		}
+		_, err := dial(tlsconfig.NobodyClientCertFile, tlsconfig.NobodyClientKeyFile, "https://"+addr)
+		require.Error(t, err)
+		_, err = dial(tlsconfig.RootClientCertFile, tlsconfig.RootClientKeyFile, "https://example.com")
+		require.Error(t, err)
+
+		ws, err := dial(tlsconfig.RootClientCertFile, tlsconfig.RootClientKeyFile, "https://"+addr)
+		require.NoError(t, err)
+		defer ws.Close()
+		recv := func() *api.Record {
+			var msg wsRecord
+			require.NoError(t, websocket.JSON.Receive(ws, &msg))
+			require.Empty(t, msg.Error)
+			record := &api.Record{}
+			require.NoError(t, protojson.Unmarshal(msg.Record, record))
+			return record
+		}
+		require.Equal(t, "a", string(recv().Value))
+		require.Equal(t, "b", string(recv().Value))
+		// the window is full until the client acknowledges
+		require.NoError(t, websocket.JSON.Send(ws, wsAck{Offset: 1}))
+		require.Equal(t, "c", string(recv().Value))
+		appendValues("d")
+		require.Equal(t, "d", string(recv().Value))
+
+		// acks past the records sent don't open the window for good
+		require.NoError(t, websocket.JSON.Send(ws, wsAck{Offset: 1 << 40}))
+		appendValues("e", "f", "g")
+		require.Equal(t, "e", string(recv().Value))
+		require.Equal(t, "f", string(recv().Value))
+		require.NoError(t, websocket.JSON.Send(ws, wsAck{Offset: 5}))
+		require.Equal(t, "g", string(recv().Value))
+	})
+}
+
+func TestHTTPTailWebSocketClose(t *testing.T) {
+	clog, _, addr, _, _, teardown := setupHTTPTest(t)
+	defer teardown()
+	_, err := clog.Append(&api.Record{Value: []byte("a")})
+	require.NoError(t, err)
+
+	config, err := websocket.NewConfig("wss://"+addr+"/tail/ws?offset=0", "https://"+addr)
+	require.NoError(t, err)
+	config.TlsConfig = clientTLSConfig(t, tlsconfig.RootClientCertFile, tlsconfig.RootClientKeyFile)
+	ws, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+	var msg wsRecord
+	require.NoError(t, websocket.JSON.Receive(ws, &msg))
+	require.Empty(t, msg.Error)
+	// an ack while the window is open must not keep the server from
+	// noticing the close while it waits on the idle log
+	require.NoError(t, websocket.JSON.Send(ws, wsAck{Offset: 0}))
+	require.NoError(t, ws.Close())
+
+	require.Eventually(t, func() bool {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		return !bytes.Contains(buf, []byte("tailWebSocket"))
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestHTTPBatches(t *testing.T) {
+	_, _, addr, root, _, teardown := setupHTTPTest(t)
+	defer teardown()
+	url := "https://" + addr
+
+	produce := func(contentType, body string) (int, *api.ProduceBatchResponse) {
+		res, err := root.Post(url+"/produce", contentType, strings.NewReader(body))
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		out := &api.ProduceBatchResponse{}
+		require.NoError(t, protojson.Unmarshal(b, out))
+		return res.StatusCode, out
+	}
+	offsets := func(res *api.ProduceBatchResponse) []uint64 {
+		var offs []uint64
+		for _, r := range res.Results {
+			require.Empty(t, r.GetError())
+			offs = append(offs, r.GetOffset())
+		}
+		return offs
+	}
+
+	code, results := produce("application/json",
+		` [{"record":{"value":"MA=="}},{"record":{"value":"MQ=="}},{"record":{"value":"Mg=="}}]`)
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, []uint64{0, 1, 2}, offsets(results))
+
+	code, results = produce("application/x-ndjson",
+		"{\"record\":{\"value\":\"Mw==\"}}\n{\"record\":{\"value\":\"NA==\"}}\n")
+	require.Equal(t, http.StatusCreated, code)
+	require.Equal(t, []uint64{3, 4}, offsets(results))
+
+	res, err := root.Post(url+"/produce", "application/json", strings.NewReader(`[{"record":{}},{}]`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	consume := func(query string) (int, *api.ConsumePage) {
+		res, err := root.Get(url + "/consume?" + query)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		p := &api.ConsumePage{}
+		if res.StatusCode == http.StatusOK {
+			b, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.NoError(t, protojson.Unmarshal(b, p))
+			require.Equal(t, fmt.Sprint(p.Next), res.Header.Get("X-Next-Offset"))
+		}
+		return res.StatusCode, p
+	}
+	values := func(p *api.ConsumePage) (got string) {
+		for _, r := range p.Records {
+			got += string(r.Value)
+		}
+		return got
+	}
+
+	// an export follows the cursor to an empty page at the end of the log
+	var all string
+	next := uint64(0)
+	for {
+		code, p := consume(fmt.Sprintf("from=%d&limit=2", next))
+		require.Equal(t, http.StatusOK, code)
+		if len(p.Records) == 0 {
+			require.Equal(t, next, p.Next)
+			break
+		}
+		require.LessOrEqual(t, len(p.Records), 2)
+		all += values(p)
+		next = p.Next
+	}
+	require.Equal(t, "01234", all)
+	require.Equal(t, uint64(5), next)
+
+	// max_bytes bounds pages but never returns an empty one mid-log
+	code, p := consume("from=1&max_bytes=1")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "1", values(p))
+	require.Equal(t, uint64(2), p.Next)
+	code, _ = consume("from=1&limit=x")
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestHTTPContentNegotiation(t *testing.T) {
+	_, _, addr, root, _, teardown := setupHTTPTest(t)
+	defer teardown()
+	url := "https://" + addr
+
+	do := func(method, path, contentType, accept string, body []byte) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, url+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := root.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, b
+	}
+
+	// a binary record round trips, bytes and all
+	record := &api.Record{Value: []byte{0, 1, 0xff}, Key: []byte("k"), DataSubject: "alice"}
+	body, err := proto.Marshal(&api.ProduceRequest{Record: record})
+	require.NoError(t, err)
+	res, b := do(http.MethodPost, "/produce", "application/x-protobuf", "application/x-protobuf", body)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	require.Equal(t, "application/x-protobuf", res.Header.Get("Content-Type"))
+	produced := &api.ProduceResponse{}
+	require.NoError(t, proto.Unmarshal(b, produced))
+	require.Equal(t, uint64(0), produced.Offset)
+
+	// a delimited stream of them is a batch
+	var stream bytes.Buffer
+	for _, v := range []string{"b", "c"} {
+		_, err := protodelim.MarshalTo(&stream, &api.ProduceRequest{Record: &api.Record{Value: []byte(v)}})
+		require.NoError(t, err)
+	}
+	res, b = do(http.MethodPost, "/produce", "application/x-protobuf; encoding=delimited", "application/x-protobuf", stream.Bytes())
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	batch := &api.ProduceBatchResponse{}
+	require.NoError(t, proto.Unmarshal(b, batch))
+	require.Len(t, batch.Results, 2)
+	require.Equal(t, uint64(2), batch.Results[1].GetOffset())
+
+	// JSON follows the protobuf mapping: camelCase names, base64 bytes
+	res, b = do(http.MethodGet, "/consume?offset=0", "", "", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	var generic map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &generic))
+	require.Equal(t, "AAH/", generic["record"]["value"])
+	require.Equal(t, "alice", generic["record"]["dataSubject"])
+
+	// NDJSON pages stream a record per line
+	res, b = do(http.MethodGet, "/consume?from=0", "", "text/html, application/x-ndjson", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	require.Equal(t, "3", res.Header.Get("X-Next-Offset"))
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	require.Len(t, lines, 3)
+	got := &api.Record{}
+	require.NoError(t, protojson.Unmarshal([]byte(lines[2]), got))
+	require.Equal(t, "c", string(got.Value))
+	require.Equal(t, uint64(2), got.Offset)
+
+	res, _ = do(http.MethodGet, "/consume?offset=0", "", "text/html", nil)
+	require.Equal(t, http.StatusNotAcceptable, res.StatusCode)
+	res, _ = do(http.MethodPost, "/produce", "text/plain", "", []byte("hello"))
+	require.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+}
@@ -0,0 +1,163 @@
+package Server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	logtp "example.com/tpmod/Api/v1"
+	logpkg "example.com/tpmod/Log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminLog is implemented by commit logs the Admin service can maintain,
+// like *log.Log.
+type AdminLog interface {
+	Truncate(lowest uint64) error
+	Roll() error
+	Segments() ([]logpkg.SegmentInfo, error)
+	Expired(logpkg.Retention) ([]logpkg.SegmentInfo, error)
+	ApplyRetention(logpkg.Retention) ([]logpkg.SegmentInfo, error)
+	DiskUsage() ([]logpkg.DirUsage, error)
+}
+
+var _ logtp.AdminServer = (*adminServer)(nil)
+
+// adminServer serves the Admin service. Every call needs the admin
+// action.
+type adminServer struct {
+	logtp.UnimplementedAdminServer
+	log        AdminLog
+	authorizer Authorizer
+}
+
+func (s *adminServer) authorize(ctx context.Context) error {
+	return authorize(ctx, s.authorizer, adminAction)
+}
+
+// unconfirmed is returned by destructive calls made without confirm.
+func unconfirmed(what string, segments []logpkg.SegmentInfo) error {
+	if len(segments) == 0 {
+		return status.Errorf(codes.FailedPrecondition,
+			"%s would remove no segment; set confirm to run it", what)
+	}
+	return status.Errorf(codes.FailedPrecondition,
+		"%s would remove %d segments holding offsets [%d, %d); set confirm to run it",
+		what, len(segments), segments[0].BaseOffset, segments[len(segments)-1].NextOffset)
+}
+
+func (s *adminServer) Truncate(ctx context.Context, req *logtp.TruncateRequest) (*logtp.TruncateResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	segments, err := s.log.Segments()
+	if err != nil {
+		return nil, err
+	}
+	var removed []logpkg.SegmentInfo
+	for _, seg := range segments[:len(segments)-1] {
+		if seg.NextOffset <= req.Lowest+1 {
+			removed = append(removed, seg)
+		}
+	}
+	if !req.Confirm {
+		return nil, unconfirmed(fmt.Sprintf("truncating to %d", req.Lowest), removed)
+	}
+	if err = s.log.Truncate(req.Lowest); err != nil {
+		return nil, err
+	}
+	return &logtp.TruncateResponse{Removed: segmentsProto(removed)}, nil
+}
+
+func (s *adminServer) RollSegment(ctx context.Context, req *logtp.RollSegmentRequest) (*logtp.RollSegmentResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.log.Roll(); err != nil {
+		return nil, err
+	}
+	segments, err := s.log.Segments()
+	if err != nil {
+		return nil, err
+	}
+	return &logtp.RollSegmentResponse{
+		Segment: segmentProto(segments[len(segments)-1]),
+	}, nil
+}
+
+func (s *adminServer) ListSegments(ctx context.Context, req *logtp.ListSegmentsRequest) (*logtp.ListSegmentsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	segments, err := s.log.Segments()
+	if err != nil {
+		return nil, err
+	}
+	return &logtp.ListSegmentsResponse{Segments: segmentsProto(segments)}, nil
+}
+
+func (s *adminServer) ApplyRetention(ctx context.Context, req *logtp.ApplyRetentionRequest) (*logtp.ApplyRetentionResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.MaxBytes == 0 && req.MaxAgeMs == 0 {
+		return nil, status.Error(codes.InvalidArgument, "set max_bytes or max_age_ms")
+	}
+	r := logpkg.Retention{
+		MaxBytes: req.MaxBytes,
+		MaxAge:   time.Duration(req.MaxAgeMs) * time.Millisecond,
+	}
+	if !req.Confirm {
+		expired, err := s.log.Expired(r)
+		if err != nil {
+			return nil, err
+		}
+		return nil, unconfirmed("retention", expired)
+	}
+	removed, err := s.log.ApplyRetention(r)
+	if err != nil {
+		return nil, err
+	}
+	return &logtp.ApplyRetentionResponse{Removed: segmentsProto(removed)}, nil
+}
+
+func (s *adminServer) GetDiskUsage(ctx context.Context, req *logtp.GetDiskUsageRequest) (*logtp.GetDiskUsageResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	usage, err := s.log.DiskUsage()
+	if err != nil {
+		return nil, err
+	}
+	res := &logtp.GetDiskUsageResponse{}
+	for _, u := range usage {
+		dir := &logtp.DirUsage{Dir: u.Dir, Bytes: u.Bytes, FreeBytes: u.Free}
+		if u.Offline != nil {
+			dir.Offline = u.Offline.Error()
+		}
+		res.Dirs = append(res.Dirs, dir)
+		res.TotalBytes += u.Bytes
+	}
+	return res, nil
+}
+
+func segmentProto(s logpkg.SegmentInfo) *logtp.Segment {
+	return &logtp.Segment{
+		BaseOffset:   s.BaseOffset,
+		NextOffset:   s.NextOffset,
+		StoreBytes:   s.StoreBytes,
+		IndexBytes:   s.IndexBytes,
+		StorePath:    s.StorePath,
+		Sealed:       s.Sealed,
+		LastModified: s.LastModified.UnixNano(),
+	}
+}
+
+func segmentsProto(segments []logpkg.SegmentInfo) []*logtp.Segment {
+	out := make([]*logtp.Segment, len(segments))
+	for i, s := range segments {
+		out[i] = segmentProto(s)
+	}
+	return out
+}
@@ -0,0 +1,195 @@
+package Server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	api "example.com/tpmod/Api/v1"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Media types of the HTTP API. JSON bodies follow the protobuf JSON
+// mapping and NDJSON bodies hold one such message per line. Protobuf
+// bodies are binary; produce requests with an encoding=delimited
+// parameter hold a stream of varint length-delimited messages.
+const (
+	jsonType     = "application/json"
+	ndjsonType   = "application/x-ndjson"
+	protobufType = "application/x-protobuf"
+)
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errNotAcceptable        = errors.New("no acceptable media type")
+)
+
+// responseType picks the media type of the response from the Accept
+// header, taking the first supported type listed. It defaults to JSON.
+func responseType(r *http.Request) (string, error) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return jsonType, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		t, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch t {
+		case jsonType, ndjsonType, protobufType:
+			return t, nil
+		case "*/*", "application/*":
+			return jsonType, nil
+		}
+	}
+	return "", errNotAcceptable
+}
+
+// decodeProduce decodes the body of a produce request and reports whether
+// it holds a batch: a JSON array, NDJSON or delimited protobuf.
+func decodeProduce(r *http.Request) ([]*api.ProduceRequest, bool, error) {
+	t, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		t = jsonType
+	}
+	body := bufio.NewReader(r.Body)
+	var reqs []*api.ProduceRequest
+	batch := true
+	switch t {
+	case jsonType:
+		var first byte
+		if first, err = firstByte(body); err != nil {
+			break
+		}
+		if first != '[' {
+			req := &api.ProduceRequest{}
+			reqs, batch, err = append(reqs, req), false, unmarshalJSON(body, req)
+			break
+		}
+		var raws []json.RawMessage
+		if err = json.NewDecoder(body).Decode(&raws); err != nil {
+			break
+		}
+		for _, raw := range raws {
+			req := &api.ProduceRequest{}
+			if err = protojson.Unmarshal(raw, req); err != nil {
+				break
+			}
+			reqs = append(reqs, req)
+		}
+	case ndjsonType:
+		dec := json.NewDecoder(body)
+		for {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err != nil {
+				break
+			}
+			req := &api.ProduceRequest{}
+			if err = protojson.Unmarshal(raw, req); err != nil {
+				break
+			}
+			reqs = append(reqs, req)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	case protobufType:
+		if params["encoding"] != "delimited" {
+			req := &api.ProduceRequest{}
+			var b []byte
+			if b, err = io.ReadAll(body); err == nil {
+				err = proto.Unmarshal(b, req)
+			}
+			reqs, batch = append(reqs, req), false
+			break
+		}
+		for {
+			req := &api.ProduceRequest{}
+			if err = protodelim.UnmarshalFrom(body, req); err != nil {
+				break
+			}
+			reqs = append(reqs, req)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	default:
+		return nil, false, errUnsupportedMediaType
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(reqs) == 0 {
+		return nil, false, errors.New("no records")
+	}
+	for i, req := range reqs {
+		if req.Record == nil {
+			return nil, false, fmt.Errorf("request %d has no record", i)
+		}
+	}
+	return reqs, batch, nil
+}
+
+func unmarshalJSON(body io.Reader, m proto.Message) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return protojson.Unmarshal(b, m)
+}
+
+// firstByte peeks at the first byte of body that is not white space.
+func firstByte(body *bufio.Reader) (byte, error) {
+	for {
+		b, err := body.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			return b, body.UnreadByte()
+		}
+	}
+}
+
+// writeMessage writes m as the body of a response of media type t.
+func writeMessage(w http.ResponseWriter, t string, code int, m proto.Message) error {
+	return writeMessages(w, t, code, m, []proto.Message{m})
+}
+
+// writeMessages writes whole as the body of a response of media type t,
+// or for NDJSON each of parts on a line of its own.
+func writeMessages(w http.ResponseWriter, t string, code int, whole proto.Message, parts []proto.Message) error {
+	var b []byte
+	var err error
+	switch t {
+	case protobufType:
+		b, err = proto.Marshal(whole)
+	case ndjsonType:
+		for _, m := range parts {
+			var line []byte
+			if line, err = protojson.Marshal(m); err != nil {
+				break
+			}
+			b = append(append(b, line...), '\n')
+		}
+	default:
+		b, err = protojson.Marshal(whole)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", t)
+	w.WriteHeader(code)
+	_, err = w.Write(b)
+	return err
+}
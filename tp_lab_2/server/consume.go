@@ -0,0 +1,135 @@
+package Server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	logtp "example.com/tpmod/Api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// consumer reads the records a ConsumeRequest asks for, from off on.
+type consumer struct {
+	log CommitLog
+	req *logtp.ConsumeRequest
+	off uint64
+	// tail waits for records to be appended even without a max wait, as
+	// streams do.
+	tail bool
+	// truncated is set once off turned out to be below the log's lowest
+	// offset, so streams stop rather than wait for it again.
+	truncated bool
+}
+
+func (c *consumer) batched() bool {
+	return c.req.MaxRecords > 0 || c.req.MaxBytes > 0
+}
+
+func (c *consumer) done() bool {
+	return c.req.EndOffset != nil && c.off >= *c.req.EndOffset
+}
+
+// next returns the next record, or batch of records, waiting up to the
+// request's max wait for the first one to be appended. It returns
+// ErrOffsetOutOfRange if none was.
+func (c *consumer) next(ctx context.Context) (*logtp.ConsumeResponse, error) {
+	if wait := c.req.MaxWaitMs; wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(wait)*time.Millisecond)
+		defer cancel()
+	}
+	if !c.batched() {
+		record, err := c.read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.off++
+		return &logtp.ConsumeResponse{Record: record}, nil
+	}
+
+	res := &logtp.ConsumeResponse{}
+	var size int
+	for !c.done() && (c.req.MaxRecords == 0 || len(res.Records) < int(c.req.MaxRecords)) {
+		var record *logtp.Record
+		var err error
+		if len(res.Records) == 0 {
+			record, err = c.read(ctx)
+		} else {
+			// send what we have rather than wait for more
+			record, err = c.log.ReadContext(ctx, c.off)
+		}
+		if len(res.Records) > 0 && isOutOfRange(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		n := proto.Size(record)
+		if c.req.MaxBytes > 0 && len(res.Records) > 0 && size+n > int(c.req.MaxBytes) {
+			break
+		}
+		size += n
+		res.Records = append(res.Records, record)
+		c.off++
+	}
+	return res, nil
+}
+
+// read returns the record at off, waiting while ctx allows for it to be
+// appended.
+func (c *consumer) read(ctx context.Context) (*logtp.Record, error) {
+	record, err := c.log.ReadContext(ctx, c.off)
+	if !isOutOfRange(err) || (!c.tail && c.req.MaxWaitMs == 0) {
+		return record, err
+	}
+	if werr := c.log.Wait(ctx, c.off); werr != nil {
+		c.truncated = isOutOfRange(werr)
+		return nil, err
+	}
+	return c.log.ReadContext(ctx, c.off)
+}
+
+// stream sends responses, tailing the log, until the end offset is
+// reached or ctx is done. acquire, when set, blocks until the client has
+// credit for one more response and reports false once it never will.
+func (c *consumer) stream(
+	ctx context.Context,
+	send func(*logtp.ConsumeResponse) error,
+	acquire func() bool,
+) error {
+	c.tail = true
+	// credit is set while holding credit for the next response
+	credit := false
+	for !c.done() {
+		if acquire != nil && !credit {
+			if !acquire() {
+				return nil
+			}
+			credit = true
+		}
+		res, err := c.next(ctx)
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return nil
+		case isOutOfRange(err) && c.req.MaxWaitMs > 0 && !c.truncated:
+			if !c.batched() {
+				continue
+			}
+			// an empty batch tells the client nothing was appended
+			res = &logtp.ConsumeResponse{}
+		default:
+			return err
+		}
+		if err = send(res); err != nil {
+			return err
+		}
+		credit = false
+	}
+	return nil
+}
+
+func isOutOfRange(err error) bool {
+	return errors.As(err, &logtp.ErrOffsetOutOfRange{})
+}
@@ -0,0 +1,77 @@
+package Server
+
+import (
+	"context"
+	"time"
+
+	logtp "example.com/tpmod/Api/v1"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthWatchInterval is how often Watch checks the log for changes.
+const healthWatchInterval = time.Second
+
+// HealthLog is implemented by commit logs that can stop taking appends,
+// like *log.Log when its disk fills up.
+type HealthLog interface {
+	ReadOnly() bool
+}
+
+// healthServer serves grpc.health.v1 for the Log service, and the empty
+// service name standing for the whole server, from the state of the log.
+// The log serves while it takes appends.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	log CommitLog
+	// services lists the other services registered, always serving.
+	services map[string]bool
+}
+
+func (h *healthServer) status(service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	switch {
+	case service == "" || service == logtp.Log_ServiceDesc.ServiceName:
+	case h.services[service]:
+		return healthpb.HealthCheckResponse_SERVING, nil
+	default:
+		return 0, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+	if hl, ok := h.log.(HealthLog); ok && hl.ReadOnly() {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, err := h.status(req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status of the service, and then every change of it.
+// Unknown services are reported as SERVICE_UNKNOWN, as the protocol asks.
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		st, err := h.status(req.Service)
+		if err != nil {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,59 +1,175 @@
 package Server
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
+	"math"
 	"net/http"
 	"strconv"
-	"sync"
 
 	api "example.com/tpmod/Api/v1"
 	logpkg "example.com/tpmod/Log"
+	"example.com/tpmod/tracing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type HTTPServer struct {
-	Log *logpkg.Log
-	mu  sync.Mutex // Mutex para proteger el acceso concurrente
+	Log        *logpkg.Log
+	Authorizer Authorizer
+	// Tracer, when set, traces requests as the gRPC server's Tracer does,
+	// continuing the span context of their traceparent header.
+	Tracer *tracing.Tracer
 }
 
-func NewHTTPServer(addr string, log *logpkg.Log) *http.Server {
+// NewHTTPServer returns a server authenticating clients by their
+// certificate, like the gRPC server. tlsConfig should come from
+// config.SetupTLSConfig with Server set so client certificates are
+// required; serve it with ListenAndServeTLS("", ""). Requests without a
+// verified certificate fail with 401 Unauthorized. tracer may be nil.
+func NewHTTPServer(
+	addr string,
+	log *logpkg.Log,
+	authorizer Authorizer,
+	tlsConfig *tls.Config,
+	tracer *tracing.Tracer,
+) *http.Server {
 	httpSrv := &HTTPServer{
-		Log: log,
+		Log:        log,
+		Authorizer: authorizer,
+		Tracer:     tracer,
 	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/produce", httpSrv.handleProduce)
-	mux.HandleFunc("/consume", httpSrv.handleConsume)
+	mux.HandleFunc("/produce", httpSrv.authorized(produceAction, httpSrv.handleProduce))
+	mux.HandleFunc("/consume", httpSrv.authorized(consumeAction, httpSrv.handleConsume))
+	mux.HandleFunc("/tail", httpSrv.authorized(consumeAction, httpSrv.handleTail))
+	mux.HandleFunc("/tail/ws", httpSrv.authorized(consumeAction, httpSrv.tailWebSocket().ServeHTTP))
 
 	return &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
 	}
 }
 
-func (s *HTTPServer) handleProduce(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock() // Bloquear el mutex antes de escribir
-	defer s.mu.Unlock()
+// authorized runs next for clients allowed to perform action, with their
+// subject in the request context as the gRPC server has it.
+func (s *HTTPServer) authorized(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := startHTTP(s.Tracer, r)
+		ctx, err := authenticateHTTP(ctx, r)
+		if err == nil {
+			err = authorize(ctx, s.Authorizer, action)
+		}
+		if err != nil {
+			span.End(err)
+			http.Error(w, status.Convert(err).Message(), httpStatus(err))
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		next(sw, r.WithContext(ctx))
+		span.End(sw.err())
+	}
+}
 
-	var req api.ProduceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Error deserializando el JSON: "+err.Error(), http.StatusBadRequest)
-		return
+// authenticateHTTP takes the subject from the common name of the client's
+// verified certificate.
+func authenticateHTTP(ctx context.Context, r *http.Request) (_ context.Context, err error) {
+	_, span := tracing.Start(ctx, "authenticate")
+	defer func() { span.End(err) }()
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no verified client certificate")
 	}
+	subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	span.SetAttribute("subject", subject)
+	return context.WithValue(ctx, subjectContextKey{}, subject), nil
+}
 
-	offset, err := s.Log.Append(req.Record)
+// handleProduce appends the record of a ProduceRequest, or the records of
+// a batch of them: a JSON array, NDJSON or delimited protobuf. Batches are
+// appended in order and written together; the response holds the offset,
+// or the error, of every record.
+func (s *HTTPServer) handleProduce(w http.ResponseWriter, r *http.Request) {
+	t, err := responseType(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	reqs, batch, err := decodeProduce(r)
+	if errors.Is(err, errUnsupportedMediaType) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error deserializando el cuerpo: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if id := r.Header.Get(traceIDMetadata); id != "" {
+		ctx = context.WithValue(ctx, traceIDContextKey{}, id)
+	}
+	if !batch {
+		offset, err := s.Log.AppendContext(ctx, reqs[0].Record)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		// Devolver el offset del nuevo registro
+		writeMessage(w, t, http.StatusCreated, &api.ProduceResponse{
+			Offset: offset,
+			Hlc:    reqs[0].Record.Hlc,
+		})
 		return
 	}
 
-	// Devolver el offset del nuevo registro
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]uint64{"offset": offset})
+	waits := make([]func() (uint64, error), len(reqs))
+	for i, req := range reqs {
+		waits[i] = s.Log.AppendAsync(ctx, req.Record)
+	}
+	res := &api.ProduceBatchResponse{}
+	parts := make([]proto.Message, len(reqs))
+	code := http.StatusCreated
+	for i, wait := range waits {
+		result := &api.ProduceResult{}
+		offset, err := wait()
+		if err != nil {
+			result.Result = &api.ProduceResult_Error{Error: err.Error()}
+			// the status is the one of the first failed record
+			if code == http.StatusCreated {
+				code = httpStatus(err)
+			}
+		} else {
+			result.Result = &api.ProduceResult_Offset{Offset: offset}
+		}
+		res.Results = append(res.Results, result)
+		parts[i] = result
+	}
+	writeMessages(w, t, code, res, parts)
 }
 
+// defaultPageRecords bounds the pages of range reads that set neither
+// limit nor max_bytes.
+const defaultPageRecords = 1000
+
+// handleConsume returns the record at the offset query parameter or, given
+// from, a page of the records from there on of at most limit records and,
+// past the first one, max_bytes bytes. Pages carry the offset the next
+// one starts at, also sent as the X-Next-Offset header; a page past the
+// end of the log is empty.
 func (s *HTTPServer) handleConsume(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock() // Bloquear el mutex antes de leer
-	defer s.mu.Unlock()
+	t, err := responseType(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	if r.URL.Query().Has("from") {
+		s.handleConsumeRange(w, r, t)
+		return
+	}
 
 	// Obtener el offset de los parámetros de la URL
 	offsetStr := r.URL.Query().Get("offset")
@@ -64,16 +180,74 @@ func (s *HTTPServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Leer el registro desde el log
-	record, err := s.Log.Read(offset)
+	record, err := s.Log.ReadContext(r.Context(), offset)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Crear una respuesta intermedia
-	resp := map[string]interface{}{"record": record}
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	writeMessage(w, t, http.StatusOK, &api.ConsumeResponse{Record: record})
+}
+
+func (s *HTTPServer) handleConsumeRange(w http.ResponseWriter, r *http.Request, t string) {
+	from, err := queryUint(r, "from", 0)
+	var limit, maxBytes uint64
+	if err == nil {
+		limit, err = queryUint(r, "limit", 0)
+	}
+	if err == nil {
+		maxBytes, err = queryUint(r, "max_bytes", 0)
+	}
+	if err == nil && (limit > math.MaxUint32 || maxBytes > math.MaxUint32) {
+		err = errors.New("limit and max_bytes must fit in 32 bits")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if limit == 0 && maxBytes == 0 {
+		limit = defaultPageRecords
+	}
+
+	c := &consumer{log: s.Log, off: from, req: &api.ConsumeRequest{
+		Offset:     from,
+		MaxRecords: uint32(limit),
+		MaxBytes:   uint32(maxBytes),
+	}}
+	res, err := c.next(r.Context())
+	if isOutOfRange(err) {
+		lowest, _ := s.Log.LowestOffset()
+		if from < lowest {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		res, err = &api.ConsumeResponse{}, nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	parts := make([]proto.Message, len(res.Records))
+	for i, record := range res.Records {
+		parts[i] = record
+	}
+	w.Header().Set("X-Next-Offset", strconv.FormatUint(c.off, 10))
+	writeMessages(w, t, http.StatusOK, &api.ConsumePage{Records: res.Records, Next: c.off}, parts)
+}
+
+// httpStatus maps an append error to an HTTP status code, so records
+// rejected by an interceptor are reported as client errors.
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,57 @@
+package Server
+
+import (
+	"context"
+	"runtime/debug"
+
+	logtp "example.com/tpmod/Api/v1"
+	logpkg "example.com/tpmod/Log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Version is the server version GetLogInfo reports. Release builds set it
+// with -ldflags "-X example.com/tpmod/server.Version=v1.2.3"; otherwise
+// it comes from the build info.
+var Version string
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// InfoLog is implemented by commit logs that can describe themselves,
+// like *log.Log.
+type InfoLog interface {
+	Info() (logpkg.Info, error)
+}
+
+func (s *grpcServer) GetLogInfo(ctx context.Context, req *logtp.GetLogInfoRequest) (*logtp.GetLogInfoResponse, error) {
+	if err := authorize(ctx, s.Authorizer, consumeAction); err != nil {
+		return nil, err
+	}
+	il, ok := s.CommitLog.(InfoLog)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "log has no info")
+	}
+	info, err := il.Info()
+	if err != nil {
+		return nil, err
+	}
+	res := &logtp.GetLogInfoResponse{
+		LowestOffset: info.LowestOffset,
+		NextOffset:   info.NextOffset,
+		Segments:     uint32(info.Segments),
+		BytesOnDisk:  info.Bytes,
+		Version:      version(),
+	}
+	if info.NextOffset > 0 {
+		res.HighestOffset = info.NextOffset - 1
+	}
+	return res, nil
+}
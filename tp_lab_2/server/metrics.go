@@ -0,0 +1,64 @@
+package Server
+
+import (
+	"context"
+	"time"
+
+	"example.com/tpmod/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serverMetrics instruments every RPC. Methods label series, which keeps
+// them bounded by the services registered.
+type serverMetrics struct {
+	handled  *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	denied   *metrics.CounterVec
+	inFlight *metrics.Gauge
+}
+
+func newServerMetrics(r *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		handled: r.CounterVec("grpc_server_handled_total",
+			"RPCs completed, by method and status code.", "method", "code"),
+		latency: r.HistogramVec("grpc_server_handling_seconds",
+			"Time to complete RPCs, streams included, by method.", metrics.DefBuckets, "method"),
+		denied: r.CounterVec("grpc_server_denied_total",
+			"RPCs refused for lack of authentication or permission, by method and status code.", "method", "code"),
+		inFlight: r.Gauge("grpc_server_in_flight",
+			"RPCs being handled."),
+	}
+}
+
+func (m *serverMetrics) observe(method string, start time.Time, err error) {
+	code := status.Code(err)
+	m.handled.With(method, code.String()).Inc()
+	m.latency.With(method).Since(start)
+	if code == codes.Unauthenticated || code == codes.PermissionDenied {
+		m.denied.With(method, code.String()).Inc()
+	}
+}
+
+func (m *serverMetrics) unary(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	start := time.Now()
+	res, err := handler(ctx, req)
+	m.observe(info.FullMethod, start, err)
+	return res, err
+}
+
+func (m *serverMetrics) stream(
+	srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	start := time.Now()
+	err := handler(srv, ss)
+	m.observe(info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,43 @@
+package Server
+
+import (
+	"context"
+
+	logtp "example.com/tpmod/Api/v1"
+	"example.com/tpmod/schema"
+)
+
+var _ logtp.SchemasServer = (*schemasServer)(nil)
+
+// schemasServer serves the schema registry. Registering a schema needs
+// the produce action and reading one the consume action.
+type schemasServer struct {
+	logtp.UnimplementedSchemasServer
+	registry   *schema.Registry
+	authorizer Authorizer
+}
+
+func (s *schemasServer) RegisterSchema(ctx context.Context, req *logtp.RegisterSchemaRequest) (*logtp.RegisterSchemaResponse, error) {
+	if err := authorize(ctx, s.authorizer, produceAction); err != nil {
+		return nil, err
+	}
+	if req.Schema == nil {
+		req.Schema = &logtp.Schema{}
+	}
+	sch, err := s.registry.Register(req.Schema)
+	if err != nil {
+		return nil, err
+	}
+	return &logtp.RegisterSchemaResponse{Schema: sch}, nil
+}
+
+func (s *schemasServer) GetSchema(ctx context.Context, req *logtp.GetSchemaRequest) (*logtp.GetSchemaResponse, error) {
+	if err := authorize(ctx, s.authorizer, consumeAction); err != nil {
+		return nil, err
+	}
+	sch, err := s.registry.Get(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &logtp.GetSchemaResponse{Schema: sch}, nil
+}
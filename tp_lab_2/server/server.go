@@ -2,14 +2,19 @@ package Server
 
 import (
 	"context"
+	"io"
 
 	logtp "example.com/tpmod/Api/v1"
+	"example.com/tpmod/metrics"
+	"example.com/tpmod/schema"
+	"example.com/tpmod/tracing"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
@@ -17,12 +22,42 @@ import (
 type Config struct {
 	CommitLog  CommitLog
 	Authorizer Authorizer
+	// MaxMessageBytes raises gRPC's 4MB limit on the messages the server
+	// receives. Larger records can always be sent with ProduceUpload.
+	MaxMessageBytes int
+	// MaxUploadBytes bounds the value of a record sent with
+	// ProduceUpload, which is buffered until the upload ends. Zero means
+	// defaultMaxUploadBytes.
+	MaxUploadBytes int
+	// Schemas, when set, is served by the Schemas service. Logs enforce
+	// its schemas by registering Schemas.Enforce as an interceptor.
+	Schemas *schema.Registry
+	// Eraser, when set, serves Erase.
+	Eraser Eraser
+	// MaxProduceInFlight bounds the records a ProduceStream has appended
+	// but not yet acknowledged. The stream stops reading requests while
+	// at the limit. Zero means defaultMaxProduceInFlight.
+	MaxProduceInFlight int
+	// Metrics, when set, gets metrics of every RPC. Serve it on its own
+	// listener with metrics.NewServer.
+	Metrics *metrics.Registry
+	// Tracer, when set, traces every RPC and, within it, authentication,
+	// authorization and the log's appends and reads. Clients propagate
+	// their span context in the traceparent metadata.
+	Tracer *tracing.Tracer
 }
 
+const (
+	defaultMaxProduceInFlight = 64
+	defaultMaxUploadBytes     = 64 << 20
+)
+
 const (
 	objectWildcard = "*"
 	produceAction  = "produce"
 	consumeAction  = "consume"
+	eraseAction    = "erase"
+	adminAction    = "admin"
 )
 
 var _ logtp.LogServer = (*grpcServer)(nil)
@@ -40,100 +75,294 @@ func newgrpcServer(config *Config) (srv *grpcServer, err error) {
 }
 
 func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
-	opts = append(opts, grpc.StreamInterceptor(
-		grpc_middleware.ChainStreamServer(
-			grpc_auth.StreamServerInterceptor(authenticate),
-		)), grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_auth.StreamServerInterceptor(authenticate),
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
 		grpc_auth.UnaryServerInterceptor(authenticate),
-	)))
+	}
+	if config.Metrics != nil {
+		// first, so RPCs failing authentication are counted too
+		m := newServerMetrics(config.Metrics)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{m.stream}, streamInterceptors...)
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{m.unary}, unaryInterceptors...)
+	}
+	if config.Tracer != nil {
+		// outermost, so every other interceptor runs within the RPC's span
+		t := rpcTracer{tracer: config.Tracer}
+		streamInterceptors = append([]grpc.StreamServerInterceptor{t.stream}, streamInterceptors...)
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{t.unary}, unaryInterceptors...)
+	}
+	opts = append(opts, grpc.StreamInterceptor(
+		grpc_middleware.ChainStreamServer(streamInterceptors...),
+	), grpc.UnaryInterceptor(
+		grpc_middleware.ChainUnaryServer(unaryInterceptors...),
+	))
+	if config.MaxMessageBytes > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(config.MaxMessageBytes))
+	}
 	gsrv := grpc.NewServer(opts...)
 	srv, err := newgrpcServer(config)
 	if err != nil {
 		return nil, err
 	}
 	logtp.RegisterLogServer(gsrv, srv)
+	health := &healthServer{log: config.CommitLog, services: make(map[string]bool)}
+	if config.Schemas != nil {
+		logtp.RegisterSchemasServer(gsrv, &schemasServer{
+			registry:   config.Schemas,
+			authorizer: config.Authorizer,
+		})
+		health.services[logtp.Schemas_ServiceDesc.ServiceName] = true
+	}
+	if al, ok := config.CommitLog.(AdminLog); ok {
+		logtp.RegisterAdminServer(gsrv, &adminServer{
+			log:        al,
+			authorizer: config.Authorizer,
+		})
+		health.services[logtp.Admin_ServiceDesc.ServiceName] = true
+	}
+	healthpb.RegisterHealthServer(gsrv, health)
 	return gsrv, nil
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *logtp.ProduceRequest) (*logtp.ProduceResponse, error) {
 
-	if err := s.Authorizer.Authorize(
-		subject(ctx),
-		objectWildcard,
-		produceAction,
-	); err != nil {
+	if err := authorize(ctx, s.Authorizer, produceAction); err != nil {
 		return nil, err
 	}
-	offset, err := s.CommitLog.Append(req.Record)
+	offset, err := s.CommitLog.AppendContext(ctx, req.Record)
 	if err != nil {
 		return nil, err
 	}
-	return &logtp.ProduceResponse{Offset: offset}, nil
+	return &logtp.ProduceResponse{Offset: offset, Hlc: req.Record.Hlc}, nil
 }
 
 func (s *grpcServer) Consume(ctx context.Context, req *logtp.ConsumeRequest) (*logtp.ConsumeResponse, error) {
-	if err := s.Authorizer.Authorize(
-		subject(ctx),
-		objectWildcard,
-		consumeAction,
-	); err != nil {
+	if err := authorize(ctx, s.Authorizer, consumeAction); err != nil {
 		return nil, err
 	}
-	record, err := s.CommitLog.Read(req.Offset)
-	if err != nil {
-		return nil, err
-	}
-	return &logtp.ConsumeResponse{Record: record}, nil
+	c := &consumer{log: s.CommitLog, req: req, off: req.Offset}
+	return c.next(ctx)
 }
 
+// ProduceStream appends records as they arrive, without waiting for the
+// previous ones to be written, and acknowledges them in order. Each
+// acknowledgement carries the correlation ID of its request.
 func (s *grpcServer) ProduceStream(stream logtp.Log_ProduceStreamServer) error {
-	for {
-		req, err := stream.Recv()
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	if err := authorize(ctx, s.Authorizer, produceAction); err != nil {
+		return err
+	}
+	max := s.MaxProduceInFlight
+	if max <= 0 {
+		max = defaultMaxProduceInFlight
+	}
+	// every pending ack holds a slot of inFlight until it is sent
+	inFlight := make(chan struct{}, max)
+	acks := make(chan pendingAck, max)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(acks)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					recvErr <- err
+				}
+				return
+			}
+			if req.Record == nil {
+				recvErr <- status.Error(codes.InvalidArgument, "record is required")
+				return
+			}
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			acks <- pendingAck{
+				id:     req.CorrelationId,
+				record: req.Record,
+				wait:   s.CommitLog.AppendAsync(ctx, req.Record),
+			}
+		}
+	}()
+	for ack := range acks {
+		offset, err := ack.wait()
 		if err != nil {
 			return err
 		}
-		res, err := s.Produce(stream.Context(), req)
-		if err != nil {
+		if err = stream.Send(&logtp.ProduceResponse{
+			Offset:        offset,
+			Hlc:           ack.record.Hlc,
+			CorrelationId: ack.id,
+		}); err != nil {
 			return err
 		}
-		if err = stream.Send(res); err != nil {
+		<-inFlight
+	}
+	select {
+	case err := <-recvErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// pendingAck is a ProduceStream record appended but not yet acknowledged.
+type pendingAck struct {
+	id     uint64
+	record *logtp.Record
+	wait   func() (uint64, error)
+}
+
+// ProduceUpload appends a record whose value arrives in chunks, so it can
+// exceed the message size limit. Uploads past MaxUploadBytes fail with
+// ResourceExhausted. There is no chunked download: Consume returns the
+// record whole, so its consumers must raise their receive limit with
+// grpc.MaxCallRecvMsgSize.
+func (s *grpcServer) ProduceUpload(stream logtp.Log_ProduceUploadServer) error {
+	if err := authorize(stream.Context(), s.Authorizer, produceAction); err != nil {
+		return err
+	}
+	max := s.MaxUploadBytes
+	if max <= 0 {
+		max = defaultMaxUploadBytes
+	}
+	var record *logtp.Record
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
 			return err
 		}
+		if record == nil {
+			record = req.Record
+			if record == nil {
+				record = &logtp.Record{}
+			}
+		}
+		if len(record.Value)+len(req.Chunk) > max {
+			return status.Errorf(codes.ResourceExhausted, "upload larger than %d bytes", max)
+		}
+		record.Value = append(record.Value, req.Chunk...)
 	}
+	if record == nil {
+		return status.Error(codes.InvalidArgument, "empty upload")
+	}
+	offset, err := s.CommitLog.AppendContext(stream.Context(), record)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&logtp.ProduceResponse{Offset: offset, Hlc: record.Hlc})
+}
+
+// Erase makes the records of a data subject unreadable; they are read back
+// with Erased set.
+func (s *grpcServer) Erase(ctx context.Context, req *logtp.EraseRequest) (*logtp.EraseResponse, error) {
+	if err := authorize(ctx, s.Authorizer, eraseAction); err != nil {
+		return nil, err
+	}
+	if s.Eraser == nil {
+		return nil, status.Error(codes.Unimplemented, "erasure is not enabled")
+	}
+	if err := s.Eraser.Erase(req.DataSubject); err != nil {
+		return nil, err
+	}
+	return &logtp.EraseResponse{}, nil
 }
 
 func (s *grpcServer) ConsumeStream(req *logtp.ConsumeRequest, stream logtp.Log_ConsumeStreamServer) error {
-	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
-			case nil:
-			case logtp.ErrOffsetOutOfRange:
-				continue
-			default:
-				return err
+	if err := authorize(stream.Context(), s.Authorizer, consumeAction); err != nil {
+		return err
+	}
+	c := &consumer{log: s.CommitLog, req: req, off: req.Offset}
+	return c.stream(stream.Context(), stream.Send, nil)
+}
+
+// ConsumeFlow streams like ConsumeStream, sending a response only when
+// the client granted credit for it.
+func (s *grpcServer) ConsumeFlow(stream logtp.Log_ConsumeFlowServer) error {
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Request == nil {
+		return status.Error(codes.InvalidArgument, "the first message needs a request")
+	}
+	if err := authorize(ctx, s.Authorizer, consumeAction); err != nil {
+		return err
+	}
+
+	grants := make(chan uint32)
+	go func() {
+		defer close(grants)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				return
 			}
-			if err = stream.Send(res); err != nil {
-				return err
+			select {
+			case grants <- req.Credits:
+			case <-ctx.Done():
+				return
 			}
-			req.Offset++
 		}
+	}()
+	credits := first.Credits
+	acquire := func() bool {
+		for credits == 0 {
+			select {
+			case n, ok := <-grants:
+				if !ok {
+					// the client will grant no more
+					return false
+				}
+				credits += n
+			case <-ctx.Done():
+				return false
+			}
+		}
+		credits--
+		return true
 	}
+	c := &consumer{log: s.CommitLog, req: first.Request, off: first.Request.Offset}
+	return c.stream(ctx, stream.Send, acquire)
 }
 
+// CommitLog is the log served by the frontends. AppendContext runs the
+// log's append interceptors with the request's context.
 type CommitLog interface {
-	Append(*logtp.Record) (uint64, error)
+	AppendContext(context.Context, *logtp.Record) (uint64, error)
 	Read(uint64) (*logtp.Record, error)
+	// ReadContext reads like Read, tracing the read within the span of
+	// the request's context.
+	ReadContext(context.Context, uint64) (*logtp.Record, error)
+	// AppendAsync queues a record behind the ones queued before it and
+	// returns a function waiting for its offset.
+	AppendAsync(context.Context, *logtp.Record) func() (uint64, error)
+	// Wait blocks until the record at the offset is appended. It fails
+	// with ErrOffsetOutOfRange for offsets truncated away.
+	Wait(context.Context, uint64) error
+}
+
+// Eraser erases the records of a data subject, like *shred.Shredder.
+type Eraser interface {
+	Erase(dataSubject string) error
 }
 
 type Authorizer interface {
 	Authorize(subject, object, action string) error
 }
 
-func authenticate(ctx context.Context) (context.Context, error) {
+func authenticate(ctx context.Context) (_ context.Context, err error) {
+	_, span := tracing.Start(ctx, "authenticate")
+	defer func() { span.End(err) }()
 	peer, ok := peer.FromContext(ctx)
 	if !ok {
 		return ctx, status.New(
@@ -150,6 +379,7 @@ func authenticate(ctx context.Context) (context.Context, error) {
 
 	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
 	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+	span.SetAttribute("subject", subject)
 	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
 	return ctx, nil
 }
@@ -0,0 +1,84 @@
+package Server
+
+import (
+	"context"
+
+	logtp "example.com/tpmod/Api/v1"
+	logpkg "example.com/tpmod/Log"
+	"example.com/tpmod/tracing"
+	"google.golang.org/grpc/metadata"
+)
+
+// Headers stamped on records by the interceptors below.
+const (
+	ProducerHeader = "producer"
+	TraceIDHeader  = "trace-id"
+)
+
+// traceIDMetadata is the gRPC metadata key, and the HTTP header, clients
+// use to send the trace ID of a produce request.
+const traceIDMetadata = "x-trace-id"
+
+// StampProducer is a log.AppendInterceptor that records the subject of the
+// authenticated client that produced a record. A producer header sent by
+// the client is dropped, so it can't be forged.
+func StampProducer(
+	ctx context.Context,
+	record *logtp.Record,
+	next logpkg.AppendFunc,
+) (uint64, error) {
+	delete(record.Headers, ProducerHeader)
+	if sub, ok := ctx.Value(subjectContextKey{}).(string); ok && sub != "" {
+		setHeader(record, ProducerHeader, sub)
+	}
+	return next(ctx, record)
+}
+
+// StampTraceID is a log.AppendInterceptor that records the trace ID sent
+// by the client along with the produce request, if any, or else the one of
+// the request's trace. Traced appends also get the span context of the
+// append in TraceParentHeader.
+func StampTraceID(
+	ctx context.Context,
+	record *logtp.Record,
+	next logpkg.AppendFunc,
+) (uint64, error) {
+	if id := traceID(ctx); id != "" {
+		setHeader(record, TraceIDHeader, id)
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		setHeader(record, TraceParentHeader, sc.String())
+	}
+	return next(ctx, record)
+}
+
+var (
+	_ logpkg.AppendInterceptor = StampProducer
+	_ logpkg.AppendInterceptor = StampTraceID
+)
+
+func setHeader(record *logtp.Record, key, value string) {
+	if record.Headers == nil {
+		record.Headers = make(map[string]string)
+	}
+	record.Headers[key] = value
+}
+
+// traceID returns the trace ID put in ctx by the HTTP frontend or sent in
+// the incoming gRPC metadata, falling back to the one of the trace in ctx.
+func traceID(ctx context.Context) string {
+	if id, ok := ctx.Value(traceIDContextKey{}).(string); ok {
+		return id
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(traceIDMetadata); len(ids) > 0 {
+			return ids[0]
+		}
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc.TraceID.String()
+	}
+	return ""
+}
+
+type traceIDContextKey struct{}
@@ -0,0 +1,192 @@
+package Server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	api "example.com/tpmod/Api/v1"
+	"golang.org/x/net/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// defaultTailWindow is the number of records a WebSocket tail sends ahead
+// of the client's acknowledgements when it does not ask for a window.
+const defaultTailWindow = 64
+
+// handleTail streams records from the offset query parameter on as
+// Server-Sent Events, tailing the log like ConsumeStream. The id of each
+// event is the record's offset, so clients reconnecting with a
+// Last-Event-ID header resume after the last record they got.
+func (s *HTTPServer) handleTail(w http.ResponseWriter, r *http.Request) {
+	off, err := tailOffset(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := &consumer{log: s.Log, req: &api.ConsumeRequest{Offset: off}, off: off}
+	err = c.stream(r.Context(), func(res *api.ConsumeResponse) error {
+		b, err := protojson.Marshal(res.Record)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "id: %d\nevent: record\ndata: %s\n\n", res.Record.Offset, b); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}, nil)
+	if err != nil {
+		// the status is gone, so the error goes out as an event
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+		flusher.Flush()
+	}
+}
+
+// tailOffset returns the offset to tail from: the one after the
+// Last-Event-ID of a reconnecting client, or the offset query parameter.
+func tailOffset(r *http.Request) (uint64, error) {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		last, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Last-Event-ID %q", id)
+		}
+		return last + 1, nil
+	}
+	return queryUint(r, "offset", 0)
+}
+
+func queryUint(r *http.Request, name string, def uint64) (uint64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// wsRecord is a message from the server on a WebSocket tail. Record is
+// in the protobuf JSON mapping, as on the rest of the HTTP API.
+type wsRecord struct {
+	Record json.RawMessage `json:"record,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// wsAck is a message from the client on a WebSocket tail. It acknowledges
+// every record up to and including Offset.
+type wsAck struct {
+	Offset uint64 `json:"ack"`
+}
+
+// tailWebSocket tails the log like handleTail over a WebSocket. It sends
+// at most window records past the last one the client acknowledged, so
+// slow clients hold the server back rather than buffer without bound.
+func (s *HTTPServer) tailWebSocket() http.Handler {
+	return websocket.Server{
+		Handshake: sameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			r := ws.Request()
+			off, err := tailOffset(r)
+			var window uint64
+			if err == nil {
+				window, err = queryUint(r, "window", defaultTailWindow)
+			}
+			if err == nil && window == 0 {
+				err = errors.New("window must be positive")
+			}
+			if err != nil {
+				websocket.JSON.Send(ws, wsRecord{Error: err.Error()})
+				return
+			}
+
+			// the request context outlives the hijacked connection, so
+			// the tail ends when the client stops reading acks instead
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			// sent is the offset after the last record sent and acked the
+			// offset after the last acknowledged one. The reader keeps
+			// acked up to date without waiting for the window to fill, so
+			// it always gets back to notice the connection closing.
+			var sent, acked atomic.Uint64
+			sent.Store(off)
+			acked.Store(off)
+			wake := make(chan struct{}, 1)
+			go func() {
+				defer cancel()
+				for {
+					var ack wsAck
+					if err := websocket.JSON.Receive(ws, &ack); err != nil {
+						return
+					}
+					// records not sent yet can't be acknowledged
+					next := sent.Load()
+					if ack.Offset < next {
+						next = ack.Offset + 1
+					}
+					if next > acked.Load() {
+						acked.Store(next)
+						select {
+						case wake <- struct{}{}:
+						default:
+						}
+					}
+				}
+			}()
+
+			c := &consumer{log: s.Log, req: &api.ConsumeRequest{Offset: off}, off: off}
+			acquire := func() bool {
+				for c.off-acked.Load() >= window {
+					select {
+					case <-wake:
+					case <-ctx.Done():
+						return false
+					}
+				}
+				return true
+			}
+			err = c.stream(ctx, func(res *api.ConsumeResponse) error {
+				b, err := protojson.Marshal(res.Record)
+				if err != nil {
+					return err
+				}
+				// stored first, so acks racing the send aren't clamped away
+				sent.Store(res.Record.Offset + 1)
+				return websocket.JSON.Send(ws, wsRecord{Record: b})
+			}, acquire)
+			if err != nil {
+				websocket.JSON.Send(ws, wsRecord{Error: err.Error()})
+			}
+		},
+	}
+}
+
+// sameOrigin rejects WebSocket handshakes from pages of other origins,
+// which browsers would otherwise let read the log with the user's
+// client certificate.
+func sameOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host != r.Host {
+		return fmt.Errorf("websocket origin %q not allowed", r.Header.Get("Origin"))
+	}
+	config.Origin = origin
+	return nil
+}
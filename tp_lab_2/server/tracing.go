@@ -0,0 +1,129 @@
+package Server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"example.com/tpmod/tracing"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TraceParentHeader is the record header StampTraceID stores the span
+// context of the append in, so consumers can continue the trace with
+// tracing.Parse and tracing.ContextWithRemote.
+const TraceParentHeader = tracing.Header
+
+// rpcTracer traces every RPC in a span named after its method, a child of
+// the span context the client sent in the traceparent metadata if any.
+type rpcTracer struct {
+	tracer *tracing.Tracer
+}
+
+func (t rpcTracer) start(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(tracing.Header); len(values) > 0 {
+			if sc, err := tracing.Parse(values[0]); err == nil {
+				ctx = tracing.ContextWithRemote(ctx, sc)
+			}
+		}
+	}
+	return t.tracer.Start(ctx, method)
+}
+
+func (t rpcTracer) unary(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, span := t.start(ctx, info.FullMethod)
+	res, err := handler(ctx, req)
+	span.End(err)
+	return res, err
+}
+
+func (t rpcTracer) stream(
+	srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	ctx, span := t.start(ss.Context(), info.FullMethod)
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+	err := handler(srv, wrapped)
+	span.End(err)
+	return err
+}
+
+// startHTTP starts the span of an HTTP request, a child of the span
+// context in its traceparent header if any.
+func startHTTP(tracer *tracing.Tracer, r *http.Request) (context.Context, *tracing.Span) {
+	ctx := r.Context()
+	if sc, err := tracing.Parse(r.Header.Get(tracing.Header)); err == nil {
+		ctx = tracing.ContextWithRemote(ctx, sc)
+	}
+	ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path)
+	return ctx, span
+}
+
+// statusWriter records the status code of the response for the request's
+// span. It passes Flush and Hijack through, which the tail handlers need.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// err fails the span of responses with an error status.
+func (w *statusWriter) err() error {
+	if w.code < http.StatusBadRequest {
+		return nil
+	}
+	return fmt.Errorf("%d %s", w.code, http.StatusText(w.code))
+}
+
+// authorize asks authorizer whether the subject in ctx may perform action,
+// in a span of its own.
+func authorize(ctx context.Context, authorizer Authorizer, action string) (err error) {
+	_, span := tracing.Start(ctx, "authorize")
+	defer func() { span.End(err) }()
+	sub := subject(ctx)
+	span.SetAttribute("subject", sub)
+	span.SetAttribute("action", action)
+	return authorizer.Authorize(sub, objectWildcard, action)
+}
@@ -0,0 +1,94 @@
+package Server
+
+import (
+	"context"
+	"errors"
+
+	logtp "example.com/tpmod/Api/v1"
+	logpkg "example.com/tpmod/Log"
+	"example.com/tpmod/merkle"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TreeLog is implemented by commit logs covering their records with a
+// Merkle tree, like *log.Log with Merkle.Enabled.
+type TreeLog interface {
+	TreeHead() (*merkle.TreeHead, error)
+	InclusionProof(off, size uint64) (uint64, [][]byte, error)
+	ConsistencyProof(size1, size2 uint64) ([][]byte, error)
+}
+
+func (s *grpcServer) treeLog(ctx context.Context) (TreeLog, error) {
+	if err := authorize(ctx, s.Authorizer, consumeAction); err != nil {
+		return nil, err
+	}
+	tl, ok := s.CommitLog.(TreeLog)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "log has no merkle tree")
+	}
+	return tl, nil
+}
+
+func (s *grpcServer) GetTreeHead(ctx context.Context, req *logtp.GetTreeHeadRequest) (*logtp.GetTreeHeadResponse, error) {
+	tl, err := s.treeLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	head, err := tl.TreeHead()
+	if err != nil {
+		return nil, treeError(err)
+	}
+	return &logtp.GetTreeHeadResponse{TreeHead: &logtp.SignedTreeHead{
+		TreeSize:  head.Size,
+		Timestamp: head.Timestamp,
+		RootHash:  head.Root,
+		Signature: head.Signature,
+	}}, nil
+}
+
+func (s *grpcServer) GetInclusionProof(ctx context.Context, req *logtp.GetInclusionProofRequest) (*logtp.GetInclusionProofResponse, error) {
+	tl, err := s.treeLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	size := req.TreeSize
+	if size == 0 {
+		head, err := tl.TreeHead()
+		if err != nil {
+			return nil, treeError(err)
+		}
+		size = head.Size
+	}
+	index, proof, err := tl.InclusionProof(req.Offset, size)
+	if err != nil {
+		return nil, treeError(err)
+	}
+	return &logtp.GetInclusionProofResponse{
+		LeafIndex: index,
+		TreeSize:  size,
+		AuditPath: proof,
+	}, nil
+}
+
+func (s *grpcServer) GetConsistencyProof(ctx context.Context, req *logtp.GetConsistencyProofRequest) (*logtp.GetConsistencyProofResponse, error) {
+	tl, err := s.treeLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := tl.ConsistencyProof(req.First, req.Second)
+	if err != nil {
+		return nil, treeError(err)
+	}
+	return &logtp.GetConsistencyProofResponse{Proof: proof}, nil
+}
+
+func treeError(err error) error {
+	switch {
+	case errors.Is(err, merkle.ErrInvalidRange):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, logpkg.ErrNoTree), errors.Is(err, logpkg.ErrNoSigningKey):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
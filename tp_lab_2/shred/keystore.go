@@ -0,0 +1,132 @@
+package shred
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const keySize = 32
+
+// ErrNoKey is returned for data subjects without a key, either because
+// none was created or because it was erased.
+var ErrNoKey = errors.New("no key for data subject")
+
+// KeyStore holds one encryption key per data subject.
+type KeyStore interface {
+	// Key returns the subject's key, creating one first if create is set
+	// and the subject has none.
+	Key(subject string, create bool) ([]byte, error)
+	// Erase destroys the subject's key.
+	Erase(subject string) error
+}
+
+var _ KeyStore = (*FileKeyStore)(nil)
+
+// FileKeyStore keeps each key in its own file, named after a hash of the
+// data subject so names are not stored in the clear.
+type FileKeyStore struct {
+	mu   sync.Mutex
+	dir  string
+	keys map[string][]byte
+}
+
+// NewFileKeyStore opens the key store in dir, creating dir if needed.
+func NewFileKeyStore(dir string) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{
+		dir:  dir,
+		keys: make(map[string][]byte),
+	}, nil
+}
+
+func (s *FileKeyStore) path(subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".key")
+}
+
+func (s *FileKeyStore) Key(subject string, create bool) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[subject]; ok {
+		return key, nil
+	}
+	name := s.path(subject)
+	key, err := os.ReadFile(name)
+	switch {
+	case err == nil:
+		if len(key) != keySize {
+			return nil, errors.New("corrupt key file " + name)
+		}
+	case errors.Is(err, os.ErrNotExist) && create:
+		if key, err = s.create(name); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, os.ErrNotExist):
+		return nil, ErrNoKey
+	default:
+		return nil, err
+	}
+	s.keys[subject] = key
+	return key, nil
+}
+
+// create writes a new random key, syncing it before any record encrypted
+// with it can be acknowledged.
+func (s *FileKeyStore) create(name string) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Write(key); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return key, nil
+}
+
+// Erase overwrites the subject's key file before removing it. Erasing a
+// subject without a key is not an error.
+func (s *FileKeyStore) Erase(subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, subject)
+	name := s.path(subject)
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteAt(make([]byte, keySize), 0); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
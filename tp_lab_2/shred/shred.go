@@ -0,0 +1,123 @@
+// Package shred encrypts the values of records holding personal data with
+// a key per data subject, so erasing the key erases the records without
+// rewriting the log.
+package shred
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	api "example.com/tpmod/Api/v1"
+	log "example.com/tpmod/Log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Shredder encrypts and decrypts record values with the keys of a
+// KeyStore. Install it on a log with
+//
+//	l.Use(s.Encrypt)
+//	l.UseRead(s.Decrypt)
+//
+// The Merkle tree of the log covers the encrypted records, so proofs for
+// them are checked against the records as stored.
+type Shredder struct {
+	keys KeyStore
+}
+
+var (
+	_ log.AppendInterceptor = (*Shredder)(nil).Encrypt
+	_ log.ReadInterceptor   = (*Shredder)(nil).Decrypt
+)
+
+// New returns a Shredder using the keys in keys.
+func New(keys KeyStore) *Shredder {
+	return &Shredder{keys: keys}
+}
+
+// Encrypt encrypts the value of records naming a data subject, creating
+// the subject's key on its first record.
+func (s *Shredder) Encrypt(ctx context.Context, record *api.Record, next log.AppendFunc) (uint64, error) {
+	if record.Encrypted || record.Erased {
+		return 0, status.Error(codes.InvalidArgument, "encrypted and erased are set by the server")
+	}
+	if record.DataSubject == "" {
+		return next(ctx, record)
+	}
+	key, err := s.keys.Key(record.DataSubject, true)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return 0, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(record.Value)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return 0, err
+	}
+	// the subject is authenticated so values cannot be moved between
+	// subjects
+	record.Value = aead.Seal(nonce, nonce, record.Value, []byte(record.DataSubject))
+	record.Encrypted = true
+	return next(ctx, record)
+}
+
+// Decrypt decrypts the value of encrypted records. Records whose subject
+// was erased come back with no value and Erased set.
+func (s *Shredder) Decrypt(record *api.Record) error {
+	if !record.Encrypted {
+		return nil
+	}
+	key, err := s.keys.Key(record.DataSubject, false)
+	if errors.Is(err, ErrNoKey) {
+		erase(record)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	n := aead.NonceSize()
+	if len(record.Value) < n {
+		erase(record)
+		return nil
+	}
+	value, err := aead.Open(nil, record.Value[:n], record.Value[n:], []byte(record.DataSubject))
+	if err != nil {
+		// written with a key erased since, the subject has a new one
+		erase(record)
+		return nil
+	}
+	record.Value = value
+	record.Encrypted = false
+	return nil
+}
+
+// Erase destroys the key of subject.
+func (s *Shredder) Erase(subject string) error {
+	if subject == "" {
+		return status.Error(codes.InvalidArgument, "data subject is required")
+	}
+	return s.keys.Erase(subject)
+}
+
+func erase(record *api.Record) {
+	record.Value = nil
+	record.Encrypted = false
+	record.Erased = true
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,60 @@
+package shred
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	api "example.com/tpmod/Api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShredder(t *testing.T) {
+	dir, err := os.MkdirTemp("", "shred-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keys, err := NewFileKeyStore(dir)
+	require.NoError(t, err)
+	s := New(keys)
+
+	var stored []*api.Record
+	next := func(_ context.Context, record *api.Record) (uint64, error) {
+		stored = append(stored, record)
+		return uint64(len(stored) - 1), nil
+	}
+	for _, record := range []*api.Record{
+		{Value: []byte("plain")},
+		{Value: []byte("ana's data"), DataSubject: "ana"},
+	} {
+		_, err := s.Encrypt(context.Background(), record, next)
+		require.NoError(t, err)
+	}
+	require.False(t, stored[0].Encrypted)
+	require.True(t, stored[1].Encrypted)
+	require.NotContains(t, string(stored[1].Value), "ana's data")
+	ciphertext := stored[1].Value
+
+	_, err = s.Encrypt(context.Background(), &api.Record{Encrypted: true}, next)
+	require.Error(t, err)
+
+	// keys survive reopening the store
+	keys, err = NewFileKeyStore(dir)
+	require.NoError(t, err)
+	s = New(keys)
+	require.NoError(t, s.Decrypt(stored[1]))
+	require.Equal(t, []byte("ana's data"), stored[1].Value)
+
+	require.NoError(t, s.Erase("ana"))
+	require.NoError(t, s.Erase("ana"))
+	erased := &api.Record{Value: ciphertext, DataSubject: "ana", Encrypted: true}
+	require.NoError(t, s.Decrypt(erased))
+	require.True(t, erased.Erased)
+	require.Nil(t, erased.Value)
+
+	// a new key for the subject does not bring old records back
+	_, err = s.Encrypt(context.Background(), &api.Record{Value: []byte("new"), DataSubject: "ana"}, next)
+	require.NoError(t, err)
+	erased = &api.Record{Value: ciphertext, DataSubject: "ana", Encrypted: true}
+	require.NoError(t, s.Decrypt(erased))
+	require.True(t, erased.Erased)
+}
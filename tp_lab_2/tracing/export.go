@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives every span once it ended. Export must be safe to call
+// concurrently.
+type Exporter interface {
+	Export(SpanData)
+}
+
+// WriterExporter writes spans to a writer as JSON, one per line. Write
+// errors are dropped, as tracing must not fail the traced operation.
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterExporter returns an exporter writing to w. Pass os.Stdout to
+// print spans.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *WriterExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(span)
+}
+
+// FileExporter appends spans to a file as a WriterExporter does.
+type FileExporter struct {
+	*WriterExporter
+	file *os.File
+}
+
+// NewFileExporter opens, or creates, the file at path to append spans to.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{WriterExporter: NewWriterExporter(f), file: f}, nil
+}
+
+// Close closes the file. Spans ended afterwards are lost.
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+// Recorder keeps spans in memory, for tests.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *Recorder) Export(span SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// Spans returns the spans exported so far, in the order they ended.
+func (r *Recorder) Spans() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
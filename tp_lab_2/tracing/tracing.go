@@ -0,0 +1,208 @@
+// Package tracing records spans of work across processes. Span contexts
+// travel between them in the W3C traceparent format, and ended spans are
+// handed to an Exporter.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is the HTTP header, and gRPC metadata key, span contexts are
+// propagated in.
+const Header = "traceparent"
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span, and the trace it belongs to, across
+// processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// String formats sc as a traceparent header value.
+func (sc SpanContext) String() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// Parse parses a traceparent header value.
+func Parse(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("malformed traceparent %q", s)
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("malformed trace ID in traceparent %q", s)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("malformed span ID in traceparent %q", s)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return errors.New("bad length or case")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// SpanData is an ended span, as exported.
+type SpanData struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Tracer starts root spans, and spans whose parent came from another
+// process, and exports them all once ended. A nil Tracer starts no spans.
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Span is a timed operation. Its methods do nothing on a nil Span, which
+// is what Start returns when there is no tracer to export it.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Start starts a span named name, a child of the span, or the remote span
+// context, in ctx if any, and returns a context holding it.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t}
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.data.ParentID = parent.SpanID.String()
+	} else {
+		rand.Read(s.sc.TraceID[:])
+	}
+	rand.Read(s.sc.SpanID[:])
+	s.data.Name = name
+	s.data.TraceID = s.sc.TraceID.String()
+	s.data.SpanID = s.sc.SpanID.String()
+	s.data.Start = time.Now()
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// Start starts a child of the span in ctx with that span's tracer. It
+// starts nothing when ctx holds no span, so code called both from traced
+// frontends and directly only records spans in the former case.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name)
+}
+
+// SpanContext returns the context to propagate for s to be the parent of
+// spans started elsewhere.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// End ends s, failed if err is not nil, and exports it. Only the first
+// call has any effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+type (
+	spanContextKey   struct{}
+	remoteContextKey struct{}
+)
+
+// SpanFromContext returns the span started in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanContextKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemote returns a context whose spans are children of sc, a
+// span of another process.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the context of the span in ctx or, if
+// none was started there, the remote one ctx got with ContextWithRemote.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteContextKey{}).(SpanContext)
+	return sc
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	sc, err := Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.String())
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := Parse(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	rec := &Recorder{}
+	tracer := NewTracer(rec)
+
+	remote, err := Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	ctx, root := tracer.Start(ContextWithRemote(context.Background(), remote), "root")
+	root.SetAttribute("method", "Produce")
+	_, child := Start(ctx, "child")
+	child.End(errors.New("failed"))
+	child.End(nil)
+	root.End(nil)
+
+	spans := rec.Spans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, "failed", spans[0].Error)
+	require.Equal(t, root.SpanContext().SpanID.String(), spans[0].ParentID)
+	require.Equal(t, "root", spans[1].Name)
+	require.Equal(t, remote.SpanID.String(), spans[1].ParentID)
+	require.Equal(t, map[string]string{"method": "Produce"}, spans[1].Attributes)
+	for _, span := range spans {
+		require.Equal(t, remote.TraceID.String(), span.TraceID)
+	}
+
+	// without a span in the context, nothing is started
+	ctx, span := Start(context.Background(), "orphan")
+	require.Nil(t, span)
+	require.Nil(t, SpanFromContext(ctx))
+	span.SetAttribute("key", "value")
+	span.End(nil)
+	var nilTracer *Tracer
+	_, span = nilTracer.Start(context.Background(), "untraced")
+	require.Nil(t, span)
+	require.Len(t, rec.Spans(), 2)
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exp, err := NewFileExporter(path)
+	require.NoError(t, err)
+	_, span := NewTracer(exp).Start(context.Background(), "root")
+	span.End(nil)
+	require.NoError(t, exp.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var data SpanData
+	require.NoError(t, json.Unmarshal(b, &data))
+	require.Equal(t, "root", data.Name)
+	require.Equal(t, span.SpanContext().TraceID.String(), data.TraceID)
+	require.Empty(t, data.ParentID)
+}